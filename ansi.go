@@ -0,0 +1,247 @@
+package preditor
+
+import (
+	"bytes"
+	"image/color"
+	"sort"
+	"strconv"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// ANSIColor is a color set by an SGR sequence: ANSIDefault, an index in the 256 color palette or a 24 bit
+// color made by ansiRGB.
+type ANSIColor int32
+
+const ANSIDefault ANSIColor = -1
+
+func ansiRGB(r, g, b int) ANSIColor {
+	return ANSIColor(1<<24 | (r&0xff)<<16 | (g&0xff)<<8 | b&0xff)
+}
+
+// ANSIStyle is the graphic rendition in effect for a piece of output.
+type ANSIStyle struct {
+	Foreground ANSIColor
+	Background ANSIColor
+	Bold       bool
+}
+
+var defaultANSIStyle = ANSIStyle{Foreground: ANSIDefault, Background: ANSIDefault}
+
+func (s ANSIStyle) IsDefault() bool {
+	return s == defaultANSIStyle
+}
+
+// ANSISpan is a range of buffer content with a non-default style.
+type ANSISpan struct {
+	Start int
+	End   int
+	Style ANSIStyle
+}
+
+// ANSIParser strips escape sequences from process output and turns SGR sequences into spans, style is kept
+// between calls since it can last for several lines.
+type ANSIParser struct {
+	style ANSIStyle
+}
+
+func NewANSIParser() *ANSIParser {
+	return &ANSIParser{style: defaultANSIStyle}
+}
+
+// Parse returns data without escape sequences and styled spans relative to returned text.
+func (p *ANSIParser) Parse(data []byte) ([]byte, []ANSISpan) {
+	if bytes.IndexByte(data, 0x1b) == -1 {
+		if p.style.IsDefault() || len(data) == 0 {
+			return data, nil
+		}
+		return data, []ANSISpan{{Start: 0, End: len(data), Style: p.style}}
+	}
+	var (
+		text  []byte
+		spans []ANSISpan
+	)
+	addText := func(bs []byte) {
+		if len(bs) == 0 {
+			return
+		}
+		start := len(text)
+		text = append(text, bs...)
+		if p.style.IsDefault() {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].End == start && spans[n-1].Style == p.style {
+			spans[n-1].End = len(text)
+			return
+		}
+		spans = append(spans, ANSISpan{Start: start, End: len(text), Style: p.style})
+	}
+	for len(data) > 0 {
+		esc := bytes.IndexByte(data, 0x1b)
+		if esc == -1 {
+			addText(data)
+			break
+		}
+		addText(data[:esc])
+		data = data[esc:]
+		if len(data) < 2 {
+			break
+		}
+		switch data[1] {
+		case '[':
+			// CSI: parameters and intermediates until a final byte in 0x40-0x7e.
+			end := 2
+			for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+				end++
+			}
+			if end == len(data) {
+				return text, spans
+			}
+			if data[end] == 'm' {
+				p.applySGR(data[2:end])
+			}
+			data = data[end+1:]
+		case ']':
+			// OSC: until BEL or ST.
+			end := bytes.IndexByte(data, 0x07)
+			st := bytes.Index(data, []byte("\x1b\\"))
+			switch {
+			case end != -1 && (st == -1 || end < st):
+				data = data[end+1:]
+			case st != -1:
+				data = data[st+2:]
+			default:
+				return text, spans
+			}
+		default:
+			data = data[2:]
+		}
+	}
+	return text, spans
+}
+
+func (p *ANSIParser) applySGR(params []byte) {
+	fields := bytes.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	codes := make([]int, 0, len(fields))
+	for _, f := range fields {
+		n, _ := strconv.Atoi(string(f))
+		codes = append(codes, n)
+	}
+	if len(codes) == 0 {
+		codes = []int{0}
+	}
+	// extendedColor parses `5;n` and `2;r;g;b` after 38 and 48.
+	extendedColor := func(i int) (ANSIColor, int) {
+		if i+1 < len(codes) && codes[i] == 5 {
+			return ANSIColor(codes[i+1] & 0xff), i + 2
+		}
+		if i+3 < len(codes) && codes[i] == 2 {
+			return ansiRGB(codes[i+1], codes[i+2], codes[i+3]), i + 4
+		}
+		return ANSIDefault, len(codes)
+	}
+	for i := 0; i < len(codes); {
+		code := codes[i]
+		i++
+		switch {
+		case code == 0:
+			p.style = defaultANSIStyle
+		case code == 1:
+			p.style.Bold = true
+		case code == 22:
+			p.style.Bold = false
+		case code >= 30 && code <= 37:
+			p.style.Foreground = ANSIColor(code - 30)
+		case code == 38:
+			p.style.Foreground, i = extendedColor(i)
+		case code == 39:
+			p.style.Foreground = ANSIDefault
+		case code >= 40 && code <= 47:
+			p.style.Background = ANSIColor(code - 40)
+		case code == 48:
+			p.style.Background, i = extendedColor(i)
+		case code == 49:
+			p.style.Background = ANSIDefault
+		case code >= 90 && code <= 97:
+			p.style.Foreground = ANSIColor(code - 90 + 8)
+		case code >= 100 && code <= 107:
+			p.style.Background = ANSIColor(code - 100 + 8)
+		}
+	}
+}
+
+var ansiBaseColors = [16]color.RGBA{
+	{0, 0, 0, 255}, {205, 49, 49, 255}, {13, 188, 121, 255}, {229, 229, 16, 255},
+	{36, 114, 200, 255}, {188, 63, 188, 255}, {17, 168, 205, 255}, {229, 229, 229, 255},
+	{102, 102, 102, 255}, {241, 76, 76, 255}, {35, 209, 139, 255}, {245, 245, 67, 255},
+	{59, 142, 234, 255}, {214, 112, 214, 255}, {41, 184, 219, 255}, {255, 255, 255, 255},
+}
+
+// ANSIColorRGBA maps c to a color, black and white follow theme background and foreground so output stays
+// readable on light themes.
+func (cfg *Config) ANSIColorRGBA(c ANSIColor, fallback color.RGBA) color.RGBA {
+	colors := cfg.CurrentThemeColors()
+	switch {
+	case c == ANSIDefault:
+		return fallback
+	case c >= 1<<24:
+		return color.RGBA{R: uint8(c >> 16), G: uint8(c >> 8), B: uint8(c), A: 255}
+	case c == 0:
+		return colors.Background.ToColorRGBA()
+	case c == 7 || c == 15:
+		return colors.Foreground.ToColorRGBA()
+	case c < 16:
+		return ansiBaseColors[c]
+	case c < 232:
+		// 6x6x6 color cube
+		steps := [6]uint8{0, 95, 135, 175, 215, 255}
+		c -= 16
+		return color.RGBA{R: steps[c/36], G: steps[c/6%6], B: steps[c%6], A: 255}
+	default:
+		gray := uint8(8 + (c-232)*10)
+		return color.RGBA{R: gray, G: gray, B: gray, A: 255}
+	}
+}
+
+// renderANSIBackgrounds draws background of styled output, it's called before text is drawn.
+func (e *BufferView) renderANSIBackgrounds(zeroLocation rl.Vector2) {
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	e.visibleANSISpans(func(span ANSISpan) {
+		if span.Style.Background == ANSIDefault {
+			return
+		}
+		bg := e.cfg.ANSIColorRGBA(span.Style.Background, color.RGBA{})
+		e.visibleSegments(span.Start, span.End, func(row int, line BufferLine, from int, to int) {
+			col := e.displayColumn(line.startIndex, from)
+			posX, posY := e.cellPosition(zeroLocation, row, col)
+			width := e.displayColumn(line.startIndex, to) - col
+			rl.DrawRectangle(posX, posY, int32(width)*int32(charSize.X), int32(charSize.Y), bg)
+		})
+	})
+}
+
+// renderANSIForegrounds draws styled output over plain text, bold is drawn a second time one pixel right.
+func (e *BufferView) renderANSIForegrounds(zeroLocation rl.Vector2, maxH float64, maxW float64) {
+	fallback := e.cfg.CurrentThemeColors().Foreground.ToColorRGBA()
+	e.visibleANSISpans(func(span ANSISpan) {
+		fg := e.cfg.ANSIColorRGBA(span.Style.Foreground, fallback)
+		if span.Style.Foreground != ANSIDefault {
+			e.renderTextRange(zeroLocation, span.Start, span.End, maxH, maxW, fg)
+		}
+		if span.Style.Bold {
+			e.renderTextRange(rl.Vector2{X: zeroLocation.X + 1, Y: zeroLocation.Y}, span.Start, span.End, maxH, maxW, fg)
+		}
+	})
+}
+
+func (e *BufferView) visibleANSISpans(f func(span ANSISpan)) {
+	if len(e.Buffer.ANSISpans) == 0 || len(e.visibleLines) == 0 {
+		return
+	}
+	start := e.visibleLines[0].startIndex
+	end := e.visibleLines[len(e.visibleLines)-1].endIndex
+	spans := e.Buffer.ANSISpans
+	for i := sort.Search(len(spans), func(i int) bool { return spans[i].End > start }); i < len(spans) && spans[i].Start < end; i++ {
+		f(spans[i])
+	}
+}
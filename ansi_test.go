@@ -0,0 +1,56 @@
+package preditor
+
+import (
+	"image/color"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestANSIParser(t *testing.T) {
+	p := NewANSIParser()
+	text, spans := p.Parse([]byte("\x1b[1;31mFAIL\x1b[0m foo \x1b[38;5;42mbar\x1b[39m\x1b[K\n"))
+	assert.Equal(t, "FAIL foo bar\n", string(text))
+	assert.Equal(t, []ANSISpan{
+		{Start: 0, End: 4, Style: ANSIStyle{Foreground: 1, Background: ANSIDefault, Bold: true}},
+		{Start: 9, End: 12, Style: ANSIStyle{Foreground: 42, Background: ANSIDefault}},
+	}, spans)
+
+	// style lasts until reset, even across lines.
+	text, spans = p.Parse([]byte("\x1b[42;38;2;1;2;3mok\n"))
+	assert.Equal(t, "ok\n", string(text))
+	assert.Equal(t, []ANSISpan{{Start: 0, End: 3, Style: ANSIStyle{Foreground: ansiRGB(1, 2, 3), Background: 2}}}, spans)
+	text, spans = p.Parse([]byte("still\n"))
+	assert.Equal(t, "still\n", string(text))
+	assert.Equal(t, []ANSISpan{{Start: 0, End: 6, Style: ANSIStyle{Foreground: ansiRGB(1, 2, 3), Background: 2}}}, spans)
+
+	text, spans = p.Parse([]byte("\x1b[m\x1b]8;;http://x\x07link\x1b]8;;\x1b\\ \x1b[94mb\x1b[22m\n"))
+	assert.Equal(t, "link b\n", string(text))
+	assert.Equal(t, []ANSISpan{{Start: 5, End: 7, Style: ANSIStyle{Foreground: 12, Background: ANSIDefault}}}, spans)
+}
+
+func TestANSIColorRGBA(t *testing.T) {
+	cfg := &defaultConfig
+	fallback := color.RGBA{R: 1, A: 255}
+	colors := cfg.CurrentThemeColors()
+	assert.Equal(t, fallback, cfg.ANSIColorRGBA(ANSIDefault, fallback))
+	assert.Equal(t, colors.Background.ToColorRGBA(), cfg.ANSIColorRGBA(0, fallback))
+	assert.Equal(t, colors.Foreground.ToColorRGBA(), cfg.ANSIColorRGBA(7, fallback))
+	assert.Equal(t, ansiBaseColors[1], cfg.ANSIColorRGBA(1, fallback))
+	assert.Equal(t, color.RGBA{R: 255, G: 0, B: 0, A: 255}, cfg.ANSIColorRGBA(196, fallback))
+	assert.Equal(t, color.RGBA{R: 8, G: 8, B: 8, A: 255}, cfg.ANSIColorRGBA(232, fallback))
+	assert.Equal(t, color.RGBA{R: 10, G: 20, B: 30, A: 255}, cfg.ANSIColorRGBA(ansiRGB(10, 20, 30), fallback))
+}
+
+func TestProcessOutputColors(t *testing.T) {
+	c := newTestContext()
+	view := NewBufferViewFromFilename(c, c.Cfg, "*Compilation*")
+	assert.NoError(t, view.RunProcess(exec.Command("printf", `plain\n\033[32mok\033[0m done\n`), nil))
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "Finished in")
+	})
+	assert.True(t, strings.HasPrefix(string(view.Buffer.Content.Bytes()), "plain\nok done\n"))
+	assert.Equal(t, []ANSISpan{{Start: 6, End: 8, Style: ANSIStyle{Foreground: 2, Background: ANSIDefault}}}, view.Buffer.ANSISpans)
+}
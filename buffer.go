@@ -7,15 +7,17 @@ import (
 	"fmt"
 	"github.com/smacker/go-tree-sitter/golang"
 	"image/color"
+	"io"
 	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"unicode"
+	"unicode/utf8"
 
 	"golang.design/x/clipboard"
 
@@ -36,132 +38,80 @@ func BufferOpenLocationInCurrentLine(c *Context) {
 		return
 	}
 
-	line := BufferGetCurrentLine(b)
-	if line == nil || len(line) < 1 {
-		return
-	}
-
-	segs := bytes.SplitN(line, []byte(":"), 4)
-	if len(segs) < 2 {
-		return
-
-	}
-
-	var targetWindow *Window
-	for _, col := range c.Windows {
-		for _, win := range col {
-			if c.ActiveWindowIndex != win.ID {
-				targetWindow = win
-				break
-			}
-		}
-	}
-
-	filename := segs[0]
-	var lineNum int
-	var col int
-	var err error
-	switch len(segs) {
-	case 3:
-		//filename:line: text
-		lineNum, err = strconv.Atoi(string(segs[1]))
-		if err != nil {
-		}
-	case 4:
-		//filename:line:col: text
-		lineNum, err = strconv.Atoi(string(segs[1]))
-		if err != nil {
-		}
-		col, err = strconv.Atoi(string(segs[2]))
-		if err != nil {
-		}
-
-	}
-	_ = SwitchOrOpenFileInWindow(c, c.Cfg, filepath.Join(c.getCWD(), string(filename)), &Position{Line: lineNum, Column: col}, targetWindow)
+	c.GotoLocation(b, b.Buffer.Lines().LineForOffset(b.Cursor.Point))
+}
 
-	c.ActiveWindowIndex = targetWindow.ID
-	return
+func NewGrepBuffer(parent *Context, cfg *Config, query GrepQuery) (*BufferView, error) {
+	return NewGrepBufferInDir(parent, cfg, parent.getCWD(), query)
 }
 
-func NewGrepBuffer(parent *Context, cfg *Config, pattern string) (*BufferView, error) {
-	cwd := parent.getCWD()
+func NewGrepBufferInDir(parent *Context, cfg *Config, cwd string, query GrepQuery) (*BufferView, error) {
 	bufferView := NewBufferViewFromFilename(parent, cfg, fmt.Sprintf("*Grep*@%s", cwd))
 
 	bufferView.Buffer.Readonly = true
+	bufferView.Locations = newLocationList(cwd)
+	parent.locationOutput = bufferView
+	countMatches := func(err error) {
+		matches, files := GrepMatchCount(bufferView.Buffer.Content.Bytes(), cwd)
+		bufferView.Buffer.Append([]byte(fmt.Sprintf("%d matches in %d files\n", matches, files)))
+	}
 	runCompileCommand := func() {
-		bufferView.Buffer.Content = nil
-		bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Pattern: %s\n", pattern))...)
-		bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Dir: %s\n", cwd))...)
-		go func() {
-			cmd := exec.Command("rg", []string{"--vimgrep", pattern}...)
-			cmd.Dir = cwd
-			since := time.Now()
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(err.Error())...)
-				bufferView.Buffer.Content = append(bufferView.Buffer.Content, '\n')
-			}
-			if bytes.Contains(output, []byte("\r")) {
-				output = bytes.Replace(output, []byte("\r"), []byte(""), -1)
-			}
-			bufferView.Buffer.Content = append(bufferView.Buffer.Content, output...)
-			bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Done in %s\n", time.Since(since)))...)
-
-		}()
-
+		bufferView.Buffer.Reset(nil)
+		bufferView.Locations.Current = -1
+		bufferView.Buffer.Append([]byte(fmt.Sprintf("Pattern: %s\n", query)))
+		bufferView.Buffer.Append([]byte(fmt.Sprintf("Dir: %s\n", cwd)))
+		if !HasRipgrep() {
+			bufferView.RunFunc(func(ctx context.Context, w io.Writer) error {
+				return NativeGrep(ctx, cwd, query, w)
+			}, countMatches)
+			return
+		}
+		cmd := exec.Command("rg", query.RipgrepArgs()...)
+		cmd.Dir = cwd
+		if err := bufferView.RunProcess(cmd, countMatches); err != nil {
+			bufferView.Buffer.Append([]byte(err.Error() + "\n"))
+		}
 	}
 
-	thisKeymap := CompileKeymap.Clone()
-	thisKeymap.BindKey(Key{K: "g"}, MakeCommand(func(b *BufferView) {
-		runCompileCommand()
-	}))
-
-	bufferView.keymaps.Push(thisKeymap)
+	if bufferView.rerun == nil {
+		bufferView.keymaps.Push(CompileKeymap)
+	}
+	bufferView.rerun = runCompileCommand
 
 	runCompileCommand()
 	return bufferView, nil
 }
 
 func NewCompilationBuffer(parent *Context, cfg *Config, command string) (*BufferView, error) {
-	cwd := parent.getCWD()
+	return NewCompilationBufferInDir(parent, cfg, parent.getCWD(), command)
+}
+
+func NewCompilationBufferInDir(parent *Context, cfg *Config, cwd string, command string) (*BufferView, error) {
 	bufferView := NewBufferViewFromFilename(parent, cfg, fmt.Sprintf("*Compilation*@%s", cwd))
 
 	bufferView.Buffer.Readonly = true
+	bufferView.Locations = newLocationList(cwd)
+	parent.locationOutput = bufferView
 	runCompileCommand := func() {
-		bufferView.Buffer.Content = nil
-		bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Command: %s\n", command))...)
-		bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Dir: %s\n", cwd))...)
-		go func() {
-			segs := strings.Split(command, " ")
-			var args []string
-			bin := segs[0]
-			if len(segs) > 1 {
-				args = segs[1:]
-			}
-			cmd := exec.Command(bin, args...)
-			cmd.Dir = cwd
-			since := time.Now()
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(err.Error())...)
-				bufferView.Buffer.Content = append(bufferView.Buffer.Content, '\n')
-			}
-			if bytes.Contains(output, []byte("\r")) {
-				output = bytes.Replace(output, []byte("\r"), []byte(""), -1)
-			}
-			bufferView.Buffer.Content = append(bufferView.Buffer.Content, output...)
-			bufferView.Buffer.Content = append(bufferView.Buffer.Content, []byte(fmt.Sprintf("Done in %s\n", time.Since(since)))...)
-
-		}()
-
+		bufferView.Buffer.Reset(nil)
+		bufferView.Locations.Current = -1
+		bufferView.Buffer.Append([]byte(fmt.Sprintf("Command: %s\n", command)))
+		bufferView.Buffer.Append([]byte(fmt.Sprintf("Dir: %s\n", cwd)))
+		cmd, err := cfg.CompileCommand(command, cwd)
+		if err == nil {
+			err = bufferView.RunProcess(cmd, func(err error) {
+				parent.SetDiagnostics(ParseDiagnostics(cfg.AllErrorFormats(), bufferView.Buffer.Content.Bytes(), cwd))
+			})
+		}
+		if err != nil {
+			bufferView.Buffer.Append([]byte(err.Error() + "\n"))
+		}
 	}
 
-	thisKeymap := CompileKeymap.Clone()
-	thisKeymap.BindKey(Key{K: "g"}, MakeCommand(func(b *BufferView) {
-		runCompileCommand()
-	}))
-
-	bufferView.keymaps.Push(thisKeymap)
+	if bufferView.rerun == nil {
+		bufferView.keymaps.Push(CompileKeymap)
+	}
+	bufferView.rerun = runCompileCommand
 
 	runCompileCommand()
 	return bufferView, nil
@@ -208,6 +158,7 @@ type FileType struct {
 	CommentLineBeginingChars []byte
 	FindRootOfProject        func(currentFilePath string) (string, error)
 	TSHighlightQuery         []byte
+	ErrorFormats             []ErrorFormat
 }
 
 var FileTypes map[string]FileType
@@ -224,11 +175,9 @@ func NewBufferView(parent *Context, cfg *Config, buffer *Buffer) *BufferView {
 	t.parent = parent
 	t.Buffer = buffer
 	t.LastCompileCommand = buffer.fileType.DefaultCompileCommand
-	t.keymaps = NewStack[Keymap](5)
+	t.keymaps = NewUnboundedStack[Keymap](5)
 	t.keymaps.Push(BufferKeymap)
-	t.ActionStack = NewStack[BufferAction](1000)
 	t.Cursor = Cursor{Point: 0, Mark: 0}
-	t.replaceTabsWithSpaces()
 	return &t
 }
 
@@ -288,19 +237,109 @@ type Search struct {
 	SearchMatches             [][]int
 	CurrentMatch              int
 	MovedAwayFromCurrentMatch bool
+	Options                   SearchOptions
+	Region                    []int // [start, end) that is searched, nil for whole buffer
+
+	generation int // incremented on every new search so results of older ones are dropped
+
+	// large files are searched a match at a time, SearchMatches are the matches found so far.
+	pattern    *regexp.Regexp
+	stream     context.CancelFunc // cancels search in progress
+	streamDone bool               // end of buffer is reached
 }
 
 type Buffer struct {
 	File     string
-	Content  []byte
+	Content  TextStorage
 	CRLF     bool
 	State    int
 	Readonly bool
+	Large    bool // file is memory mapped and can't be edited, see Config.LargeFileThreshold
+	Version  int  // incremented on every change to Content
 
 	oldTSTree   *sitter.Tree
 	highlights  []highlight
 	needParsing bool
 	fileType    FileType
+	lineIndex   *LineIndex
+	history     *UndoTree
+
+	// DiskState is state of the file when buffer was last loaded or saved.
+	DiskState FileState
+	conflict  FileState
+	journaled int // Version that was last written to journal
+
+	Diagnostics []*Diagnostic
+	ANSISpans   []ANSISpan // styles of process output, sorted by Start
+}
+
+func (b *Buffer) History() *UndoTree {
+	if b.history == nil {
+		b.history = NewUndoTree()
+	}
+	return b.history
+}
+
+func (b *Buffer) Lines() *LineIndex {
+	if b.lineIndex == nil {
+		b.lineIndex = NewLineIndex(b.Content.Bytes())
+	}
+	return b.lineIndex
+}
+
+func (b *Buffer) Insert(idx int, data []byte) {
+	if b.Large {
+		return
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > b.Content.Len() {
+		idx = b.Content.Len()
+	}
+	b.Lines().Insert(idx, data)
+	b.Content.Insert(idx, data)
+	b.shiftDiagnostics(idx, len(data))
+	b.Version++
+}
+
+// Delete removes [start, end) from the buffer and returns removed bytes.
+func (b *Buffer) Delete(start int, end int) []byte {
+	if start < 0 {
+		start = 0
+	}
+	if end > b.Content.Len() {
+		end = b.Content.Len()
+	}
+	if start >= end || b.Large {
+		return nil
+	}
+	deleted := bytes.Clone(b.Content.Slice(start, end))
+	b.Lines().Delete(start, end, deleted)
+	b.Content.Delete(start, end)
+	b.shiftDiagnostics(start, start-end)
+	b.Version++
+	return deleted
+}
+
+func (b *Buffer) Append(data []byte) {
+	b.Insert(b.Content.Len(), data)
+}
+
+func (b *Buffer) Reset(data []byte) {
+	if mapped, ok := b.Content.(*MappedText); ok {
+		// new content is not the file anymore, mapping is released and the buffer keeps data like any other.
+		mapped.Close()
+		b.Content = NewPieceTable(data)
+		b.Large = false
+	} else {
+		b.Content.Reset(data)
+	}
+	b.lineIndex = nil
+	b.history = nil
+	b.resetDiagnostics()
+	b.ANSISpans = nil
+	b.Version++
 }
 
 type QueryReplace struct {
@@ -308,8 +347,19 @@ type QueryReplace struct {
 	SearchString              string
 	ReplaceString             string
 	SearchMatches             [][]int
+	Replacements              [][]byte
 	CurrentMatch              int
 	MovedAwayFromCurrentMatch bool
+	Region                    []int // [start, end) that is searched, nil for whole buffer
+
+	steps     []queryReplaceStep
+	undoGroup bool // whole session is one undo group, it's ended by QueryReplaceExit
+}
+
+type queryReplaceStep struct {
+	match    int
+	original []int  // offsets of the match before it was replaced
+	text     []byte // replaced text, nil if match was skipped
 }
 
 type BufferView struct {
@@ -317,15 +367,19 @@ type BufferView struct {
 	Buffer                     *Buffer
 	cfg                        *Config
 	parent                     *Context
+	process                    *Process
+	rerun                      func()
+	Locations                  *LocationList
+	grepEdit                   *grepEdit
+	occur                      *occur
 	maxLine                    int32
 	maxColumn                  int32
 	NoStatusbar                bool
 	zeroLocation               rl.Vector2
 	textZeroLocation           rl.Vector2
-	bufferLines                []BufferLine
+	visibleLines               []BufferLine
 	VisibleStart               int32
 	MoveToPositionInNextRender *Position
-	OldBufferContentLen        int
 
 	keymaps *Stack[Keymap]
 
@@ -340,19 +394,6 @@ type BufferView struct {
 	QueryReplace QueryReplace
 
 	LastCompileCommand string
-
-	ActionStack *Stack[BufferAction]
-}
-
-const (
-	BufferActionType_Insert = iota + 1
-	BufferActionType_Delete
-)
-
-type BufferAction struct {
-	Type int
-	Idx  int
-	Data []byte
 }
 
 func (e *BufferView) String() string {
@@ -368,8 +409,15 @@ func (e *BufferView) IsSpecial() bool {
 }
 
 func (e *BufferView) AddBufferAction(a BufferAction) {
-	a.Data = bytes.Clone(a.Data)
-	e.ActionStack.Push(a)
+	e.Buffer.History().Record(a, e.Cursor)
+}
+
+func (e *BufferView) BeginUndoGroup() {
+	e.Buffer.History().BeginGroup(e.Cursor)
+}
+
+func (e *BufferView) EndUndoGroup() {
+	e.Buffer.History().EndGroup(e.Cursor)
 }
 func (e *BufferView) SetStateDirty() {
 	e.Buffer.State = State_Dirty
@@ -378,51 +426,88 @@ func (e *BufferView) SetStateDirty() {
 
 func (e *BufferView) SetStateClean() {
 	e.Buffer.State = State_Clean
+	e.Buffer.History().MarkSaved()
 	e.Buffer.needParsing = true
 }
 
-func (e *BufferView) replaceTabsWithSpaces() {
-	tabSize := e.Buffer.fileType.TabSize
-	if e.Buffer.fileType.TabSize == 0 {
-		tabSize = 4
+// nextRune returns index of the rune after the one starting at idx.
+func (e *BufferView) nextRune(idx int) int {
+	if idx < 0 || idx >= e.Buffer.Content.Len() {
+		return idx + 1
 	}
-	e.Buffer.Content = bytes.Replace(e.Buffer.Content, []byte("\t"), []byte(strings.Repeat(" ", tabSize)), -1)
+	_, size := utf8.DecodeRune(e.Buffer.Content.Slice(idx, idx+utf8.UTFMax))
+	return idx + size
 }
 
-func (e *BufferView) getBufferLineForIndex(i int) BufferLine {
-	for _, line := range e.bufferLines {
-		if line.startIndex <= i && line.endIndex >= i {
-			return line
-		}
+// prevRune returns index of the rune before idx.
+func (e *BufferView) prevRune(idx int) int {
+	if idx <= 0 || idx > e.Buffer.Content.Len() {
+		return idx - 1
 	}
+	_, size := utf8.DecodeLastRune(e.Buffer.Content.Slice(max(0, idx-utf8.UTFMax), idx))
+	return idx - size
+}
 
-	if len(e.bufferLines) > 0 {
-		lastLine := e.bufferLines[len(e.bufferLines)-1]
-		lastLine.endIndex++
-		return lastLine
+// tabWidth is number of cells a tab character extends to.
+func (e *BufferView) tabWidth() int {
+	if e.cfg != nil && e.cfg.TabSize > 0 {
+		return e.cfg.TabSize
 	}
-	return BufferLine{}
+	return 4
 }
 
-func (e *BufferView) BufferIndexToPosition(i int) Position {
-	if len(e.bufferLines) == 0 {
-		return Position{Line: 0, Column: i}
-	}
+// displayColumn returns screen column of idx in a line starting at lineStart.
+func (e *BufferView) displayColumn(lineStart int, idx int) int {
+	return byteutils.DisplayWidth(e.Buffer.Content.Slice(lineStart, idx), e.tabWidth())
+}
 
-	line := e.getBufferLineForIndex(i)
-	return Position{
-		Line:   line.Index,
-		Column: i - line.startIndex,
+// lineColumn returns display column of idx in line. Segments of the line in visibleLines are measured one by
+// one so statusbar doesn't slice a long line from its start every frame.
+func (e *BufferView) lineColumn(line BufferLine, idx int) int {
+	col, start := 0, line.startIndex
+	for _, segment := range e.visibleLines {
+		if segment.Index != line.Index || segment.startIndex != start {
+			continue
+		}
+		if idx < segment.endIndex {
+			break
+		}
+		col += e.displayColumn(segment.startIndex, segment.endIndex)
+		start = segment.endIndex
 	}
+	return col + e.displayColumn(start, idx)
+}
 
+// columnToIndex returns index of the rune drawn at column col of line, clamped to end of line.
+func (e *BufferView) columnToIndex(line BufferLine, col int) int {
+	// a column takes at most utf8.UTFMax bytes, rest of the line doesn't matter.
+	end := min(line.endIndex, line.startIndex+col*utf8.UTFMax)
+	return line.startIndex + byteutils.ColumnToIndex(e.Buffer.Content.Slice(line.startIndex, end), col, e.tabWidth())
 }
 
-func (e *BufferView) PositionToBufferIndex(pos Position) int {
-	if len(e.bufferLines) <= pos.Line {
-		return len(e.Buffer.Content)
+func (e *BufferView) getBufferLineForIndex(i int) BufferLine {
+	return e.bufferLine(e.Buffer.Lines().LineForOffset(i))
+}
+
+func (e *BufferView) bufferLine(n int) BufferLine {
+	lines := e.Buffer.Lines()
+	start := lines.LineStart(n)
+	end := lines.LineEnd(n)
+	return BufferLine{
+		Index:      n,
+		startIndex: start,
+		endIndex:   end,
+		ActualLine: n + 1,
+		Length:     end - start,
 	}
+}
+
+func (e *BufferView) BufferIndexToPosition(i int) Position {
+	return e.Buffer.Lines().OffsetToPosition(i)
+}
 
-	return e.bufferLines[pos.Line].startIndex + pos.Column
+func (e *BufferView) PositionToBufferIndex(pos Position) int {
+	return e.Buffer.Lines().PositionToOffset(pos)
 }
 
 func (e *BufferView) Destroy() error {
@@ -435,6 +520,8 @@ type highlight struct {
 	Color color.RGBA
 }
 
+// BufferLine is a logical line of the buffer, or in visibleLines one soft wrapped segment of it.
+// endIndex is exclusive and never includes the '\n'.
 type BufferLine struct {
 	Index      int
 	startIndex int
@@ -450,35 +537,29 @@ func sortme[T any](slice []T, pred func(t1 T, t2 T) bool) {
 }
 
 func (e *BufferView) moveCursorTo(pos rl.Vector2) error {
-	if len(e.bufferLines) < 1 {
+	if len(e.visibleLines) < 1 {
 		return nil
 	}
 
 	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
-	apprLine := math.Floor(float64((pos.Y - e.textZeroLocation.Y) / charSize.Y))
-	apprColumn := math.Floor(float64((pos.X - e.textZeroLocation.X) / charSize.X))
-
-	line := int(apprLine) + int(e.VisibleStart)
-	col := int(apprColumn)
-	if line >= len(e.bufferLines) {
-		line = len(e.bufferLines) - 1
+	row := int(math.Floor(float64((pos.Y - e.textZeroLocation.Y) / charSize.Y)))
+	col := int(math.Floor(float64((pos.X - e.textZeroLocation.X) / charSize.X)))
+	if row >= len(e.visibleLines) {
+		row = len(e.visibleLines) - 1
 	}
-
-	col -= e.getLineNumbersMaxLength()
-
-	if line < 0 {
-		line = 0
+	if row < 0 {
+		row = 0
 	}
 
-	// check if cursor should be moved back
-	if col > e.bufferLines[line].Length {
-		col = e.bufferLines[line].Length
+	if e.cfg.LineNumbers {
+		col -= e.getLineNumbersMaxLength()
 	}
+
 	if col < 0 {
 		col = 0
 	}
 
-	e.Cursor.SetBoth(e.PositionToBufferIndex(Position{Line: line, Column: col}))
+	e.Cursor.SetBoth(e.columnToIndex(e.visibleLines[row], col))
 
 	return nil
 }
@@ -487,58 +568,75 @@ func (e *BufferView) VisibleEnd() int32 {
 	return e.VisibleStart + e.maxLine
 }
 
-func (e *BufferView) renderTextRange(zeroLocation rl.Vector2, idx1 int, idx2 int, maxH float64, maxW float64, color color.RGBA) {
-	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
-	var start Position
-	var end Position
-	if idx1 > idx2 {
-		start = e.BufferIndexToPosition(idx2)
-		end = e.BufferIndexToPosition(idx1)
-	} else if idx2 > idx1 {
-		start = e.BufferIndexToPosition(idx1)
-		end = e.BufferIndexToPosition(idx2)
-	}
-	for i := start.Line; i <= end.Line; i++ {
-		if len(e.bufferLines) <= i {
+// screenPosition returns row and column of buffer index in visibleLines.
+func (e *BufferView) screenPosition(idx int) (int, int, bool) {
+	for row, line := range e.visibleLines {
+		if idx < line.startIndex {
 			break
 		}
-		var thisLineEnd int
-		var thisLineStart int
-		line := e.bufferLines[i]
-		if i == start.Line {
-			thisLineStart = start.Column
-		} else {
-			thisLineStart = 0
+		lastSegment := row == len(e.visibleLines)-1 || e.visibleLines[row+1].Index != line.Index
+		if idx < line.endIndex || (idx == line.endIndex && lastSegment) {
+			return row, e.displayColumn(line.startIndex, idx), true
 		}
+	}
 
-		if i < int(e.VisibleStart) {
+	return 0, 0, false
+}
+
+func (e *BufferView) cellPosition(zeroLocation rl.Vector2, row int, col int) (int32, int32) {
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	posX := int32(col)*int32(charSize.X) + int32(zeroLocation.X)
+	if e.cfg.LineNumbers {
+		posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
+	}
+	posY := int32(row)*int32(charSize.Y) + int32(zeroLocation.Y)
+	return posX, posY
+}
+
+// visibleSegments calls f for every part of [start, end) that is visible in the window.
+func (e *BufferView) visibleSegments(start int, end int, f func(row int, line BufferLine, from int, to int)) {
+	if start > end {
+		start, end = end, start
+	}
+	for row, line := range e.visibleLines {
+		if line.startIndex >= end {
 			break
 		}
-
-		if i < end.Line {
-			thisLineEnd = line.Length - 1
-		} else {
-			thisLineEnd = end.Column
+		from := max(start, line.startIndex)
+		to := min(end, line.endIndex)
+		if from >= to {
+			continue
 		}
-		posX := int32(thisLineStart)*int32(charSize.X) + int32(zeroLocation.X)
-		if e.cfg.LineNumbers {
-			posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
+		f(row, line, from, to)
+	}
+}
+
+func (e *BufferView) renderTextRange(zeroLocation rl.Vector2, idx1 int, idx2 int, maxH float64, maxW float64, color color.RGBA) {
+	e.visibleSegments(idx1, idx2, func(row int, line BufferLine, from int, to int) {
+		col := e.displayColumn(line.startIndex, from)
+		posX, posY := e.cellPosition(zeroLocation, row, col)
+		e.drawCells(posX, posY, col, e.Buffer.Content.Slice(from, to), color)
+	})
+}
+
+// drawCells draws text one rune per cell so wide or missing glyphs can't move rest of the line off the grid,
+// col is the column of first rune which is needed to know where tabs end.
+func (e *BufferView) drawCells(posX int32, posY int32, col int, bs []byte, color color.RGBA) {
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	for len(bs) > 0 {
+		r, size := utf8.DecodeRune(bs)
+		if r != ' ' && r != '\t' {
+			rl.DrawTextEx(e.parent.Font, string(r), rl.Vector2{X: float32(posX), Y: float32(posY)}, float32(e.parent.FontSize), 0, color)
 		}
-		posY := int32(i-int(e.VisibleStart))*int32(charSize.Y) + int32(zeroLocation.Y)
-		rl.DrawTextEx(e.parent.Font,
-			string(e.Buffer.Content[thisLineStart+line.startIndex:thisLineEnd+line.startIndex]),
-			rl.Vector2{
-				X: float32(posX), Y: float32(posY),
-			}, float32(e.parent.FontSize), 0, color)
+		width := byteutils.CellWidth(r, col, e.tabWidth())
+		posX += int32(width) * int32(charSize.X)
+		col += width
+		bs = bs[size:]
 	}
 }
 
 func (e *BufferView) AddBytesAtIndex(data []byte, idx int, addBufferAction bool) {
-	if idx >= len(e.Buffer.Content) {
-		e.Buffer.Content = append(e.Buffer.Content, data...)
-	} else {
-		e.Buffer.Content = append(e.Buffer.Content[:idx], append(data, e.Buffer.Content[idx:]...)...)
-	}
+	e.Buffer.Insert(idx, data)
 	if addBufferAction {
 		e.AddBufferAction(BufferAction{
 			Type: BufferActionType_Insert,
@@ -552,15 +650,11 @@ func (e *BufferView) RemoveRange(start int, end int, addBufferAction bool) {
 	if start < 0 {
 		start = 0
 	}
-	if end >= len(e.Buffer.Content) {
-		end = len(e.Buffer.Content)
-	}
-	rangeData := bytes.Clone(e.Buffer.Content[start:end])
-	if len(e.Buffer.Content) <= end {
-		e.Buffer.Content = e.Buffer.Content[:start]
-	} else {
-		e.Buffer.Content = append(e.Buffer.Content[:start], e.Buffer.Content[end:]...)
+	if end >= e.Buffer.Content.Len() {
+		end = e.Buffer.Content.Len()
 	}
+	rangeData := bytes.Clone(e.Buffer.Content.Slice(start, end))
+	e.Buffer.Delete(start, end)
 	if addBufferAction {
 		e.AddBufferAction(BufferAction{
 			Type: BufferActionType_Delete,
@@ -571,148 +665,134 @@ func (e *BufferView) RemoveRange(start int, end int, addBufferAction bool) {
 
 }
 
+// ReplaceContent replaces buffer content with data as a single undo step, only the changed region is
+// actually touched so cursor and history stay meaningful.
+func (e *BufferView) ReplaceContent(data []byte) {
+	old := e.Buffer.Content.Bytes()
+	prefix := 0
+	for prefix < len(old) && prefix < len(data) && old[prefix] == data[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(data)-prefix && old[len(old)-1-suffix] == data[len(data)-1-suffix] {
+		suffix++
+	}
+	e.BeginUndoGroup()
+	defer e.EndUndoGroup()
+	e.RemoveRange(prefix, len(old)-suffix, true)
+	e.AddBytesAtIndex(data[prefix:len(data)-suffix], prefix, true)
+	e.Cursor.Point = min(e.Cursor.Point, e.Buffer.Content.Len())
+	e.Cursor.Mark = min(e.Cursor.Mark, e.Buffer.Content.Len())
+}
+
 func (e *BufferView) getLineNumbersMaxLength() int {
-	return len(fmt.Sprint(len(e.bufferLines))) + 1
+	return len(fmt.Sprint(e.Buffer.Lines().LineCount())) + 1
 }
 
 func BufferGetCurrentLine(e *BufferView) []byte {
-	pos := e.BufferIndexToPosition(e.Cursor.Point)
-
-	if pos.Line < len(e.bufferLines) {
-		line := e.bufferLines[pos.Line]
-		return e.Buffer.Content[line.startIndex:line.endIndex]
-	}
-
-	return nil
+	line := e.getBufferLineForIndex(e.Cursor.Point)
+	return e.Buffer.Content.Slice(line.startIndex, line.endIndex)
 }
 
 func (e *BufferView) highlightBetweenTwoIndexes(zeroLocation rl.Vector2, idx1 int, idx2 int, maxH float64, maxW float64, bg color.RGBA, fg color.RGBA) {
 	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
-	var start Position
-	var end Position
 	if idx1 > idx2 {
-		start = e.BufferIndexToPosition(idx2)
-		end = e.BufferIndexToPosition(idx1)
-	} else if idx2 > idx1 {
-		start = e.BufferIndexToPosition(idx1)
-		end = e.BufferIndexToPosition(idx2)
-	}
-	for i := start.Line; i <= end.Line; i++ {
-		if len(e.bufferLines) <= i {
-			break
-		}
-		var thisLineEnd int
-		var thisLineStart int
-		line := e.bufferLines[i]
-		if i == start.Line {
-			thisLineStart = start.Column
-		} else {
-			thisLineStart = 0
-		}
-
-		if i < end.Line {
-			thisLineEnd = line.Length - 1
-		} else {
-			thisLineEnd = end.Column
-		}
-		posX := int32(thisLineStart)*int32(charSize.X) + int32(zeroLocation.X)
-		if e.cfg.LineNumbers {
-			posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
-		}
-
-		posY := int32(i-int(e.VisibleStart))*int32(charSize.Y) + int32(zeroLocation.Y)
+		idx1, idx2 = idx2, idx1
+	}
+	e.visibleSegments(idx1, e.nextRune(idx2), func(row int, line BufferLine, from int, to int) {
+		col := e.displayColumn(line.startIndex, from)
+		posX, posY := e.cellPosition(zeroLocation, row, col)
 		if !isVisibleInWindow(float64(posX), float64(posY), zeroLocation, maxH, maxW) {
-			continue
+			return
 		}
-		rl.DrawTextEx(e.parent.Font, string(e.Buffer.Content[thisLineStart+line.startIndex:thisLineEnd+line.startIndex+1]),
-			rl.Vector2{X: float32(posX), Y: float32(posY)}, float32(e.parent.FontSize), 0, fg)
+		e.drawCells(posX, posY, col, e.Buffer.Content.Slice(from, to), fg)
+		width := e.displayColumn(line.startIndex, to) - col
+		rl.DrawRectangle(posX, posY, int32(width)*int32(charSize.X), int32(charSize.Y), rl.Fade(bg, 0.5))
+	})
+}
 
-		for j := thisLineStart; j <= thisLineEnd; j++ {
-			posX := int32(j)*int32(charSize.X) + int32(zeroLocation.X)
-			if e.cfg.LineNumbers {
-				posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
-			}
-			posY := int32(i-int(e.VisibleStart))*int32(charSize.Y) + int32(zeroLocation.Y)
-			if !isVisibleInWindow(float64(posX), float64(posY), zeroLocation, maxH, maxW) {
-				continue
-			}
-			rl.DrawRectangle(posX, posY, int32(charSize.X), int32(charSize.Y), rl.Fade(bg, 0.5))
-		}
+type SearchOptions struct {
+	Literal       bool
+	CaseSensitive bool
+	WholeWord     bool
+}
+
+func (o SearchOptions) String() string {
+	var flags []string
+	if o.Literal {
+		flags = append(flags, "literal")
+	} else {
+		flags = append(flags, "regex")
+	}
+	if o.CaseSensitive {
+		flags = append(flags, "case")
+	}
+	if o.WholeWord {
+		flags = append(flags, "word")
 	}
+	return strings.Join(flags, ",")
+}
 
+func compileSearchPattern(pattern string, opts SearchOptions) (*regexp.Regexp, error) {
+	if opts.Literal {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.WholeWord {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+	// buffers are matched as a whole, ^ and $ match at line boundaries like they do in grep.
+	flags := "m"
+	if !opts.CaseSensitive {
+		flags += "i"
+	}
+	return regexp.Compile("(?" + flags + ")" + pattern)
 }
 
-func (e *BufferView) convertBufferIndexToLineAndColumn(idx int) *Position {
-	for lineIndex, line := range e.bufferLines {
-		if line.startIndex <= idx && line.endIndex >= idx {
-			return &Position{
-				Line:   lineIndex,
-				Column: idx - line.startIndex,
-			}
+// matchPattern returns [start, end] of every match, end is inclusive. empty matches are ignored.
+func matchPattern(data []byte, re *regexp.Regexp) [][]int {
+	var matched [][]int
+	for _, loc := range re.FindAllIndex(data, -1) {
+		if loc[1] > loc[0] {
+			matched = append(matched, []int{loc[0], loc[1] - 1})
 		}
 	}
-
-	return nil
+	return matched
 }
 
 func matchPatternCaseInsensitive(data []byte, pattern []byte) [][]int {
 	if len(data) == 0 || len(pattern) == 0 {
 		return nil
 	}
-	var matched [][]int
-	var buf []byte
-	start := -1
-	for i, b := range data {
-
-		if len(pattern) == len(buf) {
-			matched = append(matched, []int{start, i - 1})
-			buf = nil
-			start = -1
-		}
-		idxToCheck := len(buf)
-		if idxToCheck == 0 {
-			start = i
-		}
-		if unicode.ToLower(rune(pattern[idxToCheck])) == unicode.ToLower(rune(b)) {
-			buf = append(buf, b)
-		} else {
-			buf = nil
-			start = -1
-		}
-	}
-
-	return matched
+	re, _ := compileSearchPattern(string(pattern), SearchOptions{Literal: true})
+	return matchPattern(data, re)
 }
 
-func findNextMatch(data []byte, idx int, pattern []byte) []int {
-	var buf []byte
-	start := -1
-	for i := idx; i < len(data); i++ {
-		if len(pattern) == len(buf) {
-			return []int{start, i - 1}
-		}
-		idxToCheck := len(buf)
-		if idxToCheck == 0 {
-			start = i
+func findNextMatch(data []byte, idx int, re *regexp.Regexp) []int {
+	for idx <= len(data) {
+		loc := re.FindIndex(data[idx:])
+		if loc == nil {
+			return nil
 		}
-		if unicode.ToLower(rune(pattern[idxToCheck])) == unicode.ToLower(rune(data[i])) {
-			buf = append(buf, data[i])
-		} else {
-			buf = nil
-			start = -1
+		if loc[1] > loc[0] {
+			return []int{idx + loc[0], idx + loc[1] - 1}
 		}
+		idx += loc[0] + 1
 	}
 
 	return nil
 }
 
-func matchPatternAsync(dst *[][]int, data []byte, pattern []byte) {
+// matchPatternAsync searches data in background and calls done with the matches on main loop.
+func matchPatternAsync(c *Context, data []byte, re *regexp.Regexp, done func(matches [][]int)) {
 	go func() {
-		*dst = matchPatternCaseInsensitive(data, pattern)
+		matches := matchPattern(data, re)
+		c.Post(func(c *Context) {
+			done(matches)
+		})
 	}()
 }
 
-func TSHighlights(fileType *FileType, cfg *Config, queryString []byte, prev *sitter.Tree, code []byte) ([]highlight, *sitter.Tree, error) {
+func TSHighlights(fileType *FileType, cfg *Config, queryString []byte, prev *sitter.Tree, code TextStorage) ([]highlight, *sitter.Tree, error) {
 	var highlights []highlight
 	parser := sitter.NewParser()
 	if fileType.TSLanguage == nil {
@@ -720,7 +800,12 @@ func TSHighlights(fileType *FileType, cfg *Config, queryString []byte, prev *sit
 	}
 	parser.SetLanguage(fileType.TSLanguage)
 
-	tree, err := parser.ParseCtx(context.Background(), prev, code)
+	tree, err := parser.ParseInputCtx(context.Background(), prev, sitter.Input{
+		Read: func(offset uint32, _ sitter.Point) []byte {
+			return code.Chunk(int(offset))
+		},
+		Encoding: sitter.InputEncodingUTF8,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -752,6 +837,14 @@ func TSHighlights(fileType *FileType, cfg *Config, queryString []byte, prev *sit
 	return highlights, tree, nil
 }
 
+func isBracket(b byte) bool {
+	switch b {
+	case '{', '}', '[', ']', '(', ')':
+		return true
+	}
+	return false
+}
+
 func safeSlice[T any](s []T, start int, end int) []T {
 	if len(s) == 0 {
 		return nil
@@ -771,76 +864,54 @@ type bufferRenderContext struct {
 	zeroLocation     rl.Vector2
 }
 
+// calcRenderState computes visual lines of the part of buffer that is visible in the window,
+// long lines are soft wrapped at window width.
 func (e *BufferView) calcRenderState() {
-	e.bufferLines = []BufferLine{}
-	totalVisualLines := 0
-	lineCharCounter := 0
-	var actualLineIndex = 1
-	var start int
-	for idx, char := range e.Buffer.Content {
-		lineCharCounter++
-		if char == '\n' {
-			line := BufferLine{
-				Index:      totalVisualLines,
-				startIndex: start,
-				endIndex:   idx,
-				Length:     idx - start + 1,
-				ActualLine: actualLineIndex,
-			}
-			e.bufferLines = append(e.bufferLines, line)
-			totalVisualLines++
-			actualLineIndex++
-			lineCharCounter = 0
-			start = idx + 1
+	e.visibleLines = e.visibleLines[:0]
+	lines := e.Buffer.Lines()
+	wrapAt := 0
+	if e.maxColumn > 0 {
+		wrapAt = int(e.maxColumn) - 1
+		if e.cfg.LineNumbers {
+			wrapAt -= e.getLineNumbersMaxLength()
 		}
-		if idx == len(e.Buffer.Content)-1 {
-			// last index
-			line := BufferLine{
-				Index:      totalVisualLines,
-				startIndex: start,
-				endIndex:   idx + 1,
-				Length:     idx - start + 1,
-				ActualLine: actualLineIndex,
+	}
+	for n := int(e.VisibleStart); n < lines.LineCount() && len(e.visibleLines) < int(e.maxLine); n++ {
+		start, end := lines.LineStart(n), lines.LineEnd(n)
+		for len(e.visibleLines) < int(e.maxLine) {
+			segmentEnd := end
+			if wrapAt > 0 && segmentEnd-start > wrapAt {
+				// a column takes at most utf8.UTFMax bytes, so the segment is within this many bytes.
+				window := e.Buffer.Content.Slice(start, min(end, start+wrapAt*utf8.UTFMax))
+				segmentEnd = start + max(byteutils.ColumnToIndex(window, wrapAt, e.tabWidth()), 1)
 			}
-			e.bufferLines = append(e.bufferLines, line)
-			totalVisualLines++
-			actualLineIndex++
-			lineCharCounter = 0
-			start = idx + 1
-		}
-		if e.maxColumn != 0 && int32(lineCharCounter) > e.maxColumn-5 {
-			line := BufferLine{
-				Index:      totalVisualLines,
+			e.visibleLines = append(e.visibleLines, BufferLine{
+				Index:      n,
 				startIndex: start,
-				endIndex:   idx,
-				Length:     idx - start + 1,
-				ActualLine: actualLineIndex,
+				endIndex:   segmentEnd,
+				ActualLine: n + 1,
+				Length:     segmentEnd - start,
+			})
+			if segmentEnd == end {
+				break
 			}
-			e.bufferLines = append(e.bufferLines, line)
-			totalVisualLines++
-			lineCharCounter = 0
-			start = idx + 1
+			start = segmentEnd
 		}
 	}
-
 }
 
 func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64) {
-	oldMaxLine := e.maxLine
-	oldMaxColumn := e.maxColumn
-	oldBufferContentLen := e.OldBufferContentLen
 	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
 	e.maxColumn = int32(maxW / float64(charSize.X))
 	e.maxLine = int32(maxH / float64(charSize.Y))
 	e.maxLine-- //reserve one line of screen for statusbar
+	if e.occur != nil {
+		e.refreshOccur()
+	}
 	textZeroLocation := zeroLocation
 	if e.Search.IsSearching || e.QueryReplace.IsQueryReplace {
 		textZeroLocation.Y += charSize.Y
 	}
-	if e.Buffer.needParsing || len(e.Buffer.Content) != oldBufferContentLen || (e.maxLine != oldMaxLine) || (e.maxColumn != oldMaxColumn) {
-		e.calcRenderState()
-	}
-	e.OldBufferContentLen = len(e.Buffer.Content)
 	if !e.NoStatusbar && !e.parent.GlobalNoStatusbar {
 		var sections []string
 
@@ -855,21 +926,23 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 		sections = append(sections, fmt.Sprintf("%s %s", state, file))
 
 		if e.Cursor.Start() == e.Cursor.End() {
-			selStart := e.BufferIndexToPosition(e.Cursor.Start())
-			if len(e.bufferLines) > selStart.Line {
-				selLine := e.bufferLines[selStart.Line]
-				sections = append(sections, fmt.Sprintf("L#%d C#%d", selLine.ActualLine, selStart.Column))
-			} else {
-				sections = append(sections, fmt.Sprintf("L#%d C#%d", selStart.Line, selStart.Column))
-			}
-
+			selStart := e.getBufferLineForIndex(e.Cursor.Start())
+			sections = append(sections, fmt.Sprintf("L#%d C#%d", selStart.Index+1, e.lineColumn(selStart, e.Cursor.Start())))
 		} else {
-			selEnd := e.BufferIndexToPosition(e.Cursor.End())
-			sections = append(sections, fmt.Sprintf("L#%d C#%d (Selected %d)", selEnd.Line, selEnd.Column, int(math.Abs(float64(e.Cursor.Start()-e.Cursor.End())))))
+			selEnd := e.getBufferLineForIndex(e.Cursor.End())
+			sections = append(sections, fmt.Sprintf("L#%d C#%d (Selected %d)", selEnd.Index+1, e.lineColumn(selEnd, e.Cursor.End()), int(math.Abs(float64(e.Cursor.Start()-e.Cursor.End())))))
+		}
+
+		if e.Buffer.Large {
+			sections = append(sections, e.Buffer.largeFileStatus())
 		}
 
 		if e.Search.IsSearching {
-			sections = append(sections, fmt.Sprintf("Search: Match#%d Of %d", e.Search.CurrentMatch+1, len(e.Search.SearchMatches)+1))
+			sections = append(sections, "Search: "+e.Search.MatchCount())
+		}
+
+		if e.parent.ActiveDrawableID() == e.ID && e.parent.StatusMessage != "" && time.Since(e.parent.StatusMessageTime) < statusMessageTimeout {
+			sections = append(sections, e.parent.StatusMessage)
 		}
 
 		bg := e.cfg.CurrentThemeColors().StatusBarBackground.ToColorRGBA()
@@ -904,11 +977,9 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 
 	if e.MoveToPositionInNextRender != nil {
 		var bufferIndex int
-		for _, line := range e.bufferLines {
-			if line.ActualLine == e.MoveToPositionInNextRender.Line {
-				bufferIndex = line.startIndex + e.MoveToPositionInNextRender.Column
-				e.VisibleStart = int32(e.MoveToPositionInNextRender.Line) - e.maxLine/2
-			}
+		if line := e.MoveToPositionInNextRender.Line - 1; line >= 0 && line < e.Buffer.Lines().LineCount() {
+			bufferIndex = e.PositionToBufferIndex(Position{Line: line, Column: e.MoveToPositionInNextRender.Column})
+			e.VisibleStart = int32(line) - e.maxLine/2
 		}
 		e.Cursor.SetBoth(bufferIndex)
 		e.MoveToPositionInNextRender = nil
@@ -923,30 +994,31 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 	}
 
 	if e.Search.IsSearching {
-		for idx, match := range e.Search.SearchMatches {
-			if idx == e.Search.CurrentMatch {
-				matchStartLine := e.BufferIndexToPosition(match[0])
-				matchEndLine := e.BufferIndexToPosition(match[0])
-				if !(e.VisibleStart < int32(matchStartLine.Line) && e.VisibleEnd() > int32(matchEndLine.Line)) && !e.Search.MovedAwayFromCurrentMatch {
-					// current match is not in view
-					// move the view
-					e.VisibleStart = int32(matchStartLine.Line) - e.maxLine/2
-					if e.VisibleStart < 0 {
-						e.VisibleStart = int32(matchStartLine.Line)
-					}
-
+		if e.Search.CurrentMatch < len(e.Search.SearchMatches) {
+			matchStartLine := e.BufferIndexToPosition(e.Search.SearchMatches[e.Search.CurrentMatch][0])
+			if !(e.VisibleStart < int32(matchStartLine.Line) && e.VisibleEnd() > int32(matchStartLine.Line)) && !e.QueryReplace.MovedAwayFromCurrentMatch {
+				// current match is not in view
+				// move the view
+				e.VisibleStart = int32(matchStartLine.Line) - e.maxLine/2
+				if e.VisibleStart < 0 {
+					e.VisibleStart = int32(matchStartLine.Line)
 				}
 			}
-			e.highlightBetweenTwoIndexes(textZeroLocation, match[0], match[1], maxH, maxW, e.cfg.CurrentThemeColors().SelectionBackground.ToColorRGBA(), e.cfg.CurrentThemeColors().SelectionForeground.ToColorRGBA())
 		}
+		e.highlightMatches(textZeroLocation, e.Search.SearchMatches, maxH, maxW)
+		e.renderMatchMarkers(textZeroLocation, maxH, maxW, e.Search.SearchMatches, e.Search.CurrentMatch)
 		e.Search.LastSearchString = e.Search.SearchString
-		if len(e.Search.SearchMatches) > 0 {
+		if e.Search.CurrentMatch < len(e.Search.SearchMatches) {
 			e.Cursor.Point = e.Search.SearchMatches[e.Search.CurrentMatch][0]
 			e.Cursor.Mark = e.Search.SearchMatches[e.Search.CurrentMatch][0]
 		}
 
 		rl.DrawRectangle(int32(zeroLocation.X), int32(zeroLocation.Y), int32(maxW), int32(charSize.Y), e.cfg.CurrentThemeColors().Prompts.ToColorRGBA())
-		rl.DrawTextEx(e.parent.Font, fmt.Sprintf("Search: %s", e.Search.SearchString), rl.Vector2{
+		searchPrompt := fmt.Sprintf("Search%s [%s]: %s  %s", regionLabel(e.Search.Region), e.Search.Options, e.Search.SearchString, e.Search.MatchCount())
+		if e.parent.Prompt.Error != "" {
+			searchPrompt += "  (" + e.parent.Prompt.Error + ")"
+		}
+		rl.DrawTextEx(e.parent.Font, searchPrompt, rl.Vector2{
 			X: zeroLocation.X,
 			Y: zeroLocation.Y,
 		}, float32(e.parent.FontSize), 0, rl.White)
@@ -955,49 +1027,48 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 
 	//QueryReplace
 	if e.QueryReplace.IsQueryReplace {
-		for idx, match := range e.QueryReplace.SearchMatches {
-			if idx == e.QueryReplace.CurrentMatch {
-				matchStartLine := e.BufferIndexToPosition(match[0])
-				matchEndLine := e.BufferIndexToPosition(match[0])
-				if !(e.VisibleStart < int32(matchStartLine.Line) && e.VisibleEnd() > int32(matchEndLine.Line)) && !e.Search.MovedAwayFromCurrentMatch {
-					// current match is not in view
-					// move the view
-					e.VisibleStart = int32(matchStartLine.Line) - e.maxLine/2
-					if e.VisibleStart < 0 {
-						e.VisibleStart = int32(matchStartLine.Line)
-					}
-
+		remaining := e.QueryReplace.SearchMatches[min(e.QueryReplace.CurrentMatch, len(e.QueryReplace.SearchMatches)):]
+		if len(remaining) > 0 {
+			matchStartLine := e.BufferIndexToPosition(remaining[0][0])
+			if !(e.VisibleStart < int32(matchStartLine.Line) && e.VisibleEnd() > int32(matchStartLine.Line)) && !e.QueryReplace.MovedAwayFromCurrentMatch {
+				// current match is not in view
+				// move the view
+				e.VisibleStart = int32(matchStartLine.Line) - e.maxLine/2
+				if e.VisibleStart < 0 {
+					e.VisibleStart = int32(matchStartLine.Line)
 				}
 			}
-			e.highlightBetweenTwoIndexes(textZeroLocation, match[0], match[1], maxH, maxW, e.cfg.CurrentThemeColors().SelectionBackground.ToColorRGBA(), e.cfg.CurrentThemeColors().SelectionForeground.ToColorRGBA())
 		}
-		if len(e.QueryReplace.SearchMatches) > 0 {
-			e.Cursor.Point = e.QueryReplace.SearchMatches[e.QueryReplace.CurrentMatch][0]
-			e.Cursor.Mark = e.QueryReplace.SearchMatches[e.QueryReplace.CurrentMatch][0]
+		e.highlightMatches(textZeroLocation, remaining, maxH, maxW)
+		e.renderMatchMarkers(textZeroLocation, maxH, maxW, remaining, 0)
+		if len(remaining) > 0 {
+			e.Cursor.Point = remaining[0][0]
+			e.Cursor.Mark = remaining[0][0]
 		}
 
 		rl.DrawRectangle(int32(zeroLocation.X), int32(zeroLocation.Y), int32(maxW), int32(charSize.Y), e.cfg.CurrentThemeColors().Prompts.ToColorRGBA())
-		rl.DrawTextEx(e.parent.Font, fmt.Sprintf("QueryReplace: %s -> %s", e.QueryReplace.SearchString, e.QueryReplace.ReplaceString), rl.Vector2{
+		rl.DrawTextEx(e.parent.Font, fmt.Sprintf("QueryReplace%s: %s -> %s  %s", regionLabel(e.QueryReplace.Region), e.QueryReplace.SearchString, e.QueryReplace.ReplaceString, matchCount(e.QueryReplace.CurrentMatch, e.QueryReplace.SearchMatches)), rl.Vector2{
 			X: zeroLocation.X,
 			Y: zeroLocation.Y,
 		}, float32(e.parent.FontSize), 0, rl.White)
 
 	}
 
-	var visibleLines []BufferLine
+	if !e.Search.IsSearching && !e.QueryReplace.IsQueryReplace {
+		e.renderSearchOfOtherView(textZeroLocation, maxH, maxW)
+	}
+
 	if e.VisibleStart < 0 {
 		e.VisibleStart = 0
 	}
-
-	if e.VisibleEnd() > int32(len(e.bufferLines)) {
-		visibleLines = e.bufferLines[e.VisibleStart:]
-	} else {
-		visibleLines = e.bufferLines[e.VisibleStart:e.VisibleEnd()]
+	e.calcRenderState()
+	if len(e.visibleLines) > 0 {
+		e.parent.RequireCodepoints(e.Buffer.Content.Slice(e.visibleLines[0].startIndex, e.visibleLines[len(e.visibleLines)-1].endIndex))
 	}
 
-	//TODO: @Perf we should check and re render if view has changed or buffer lines has changed
-	for idx, line := range visibleLines {
-		if e.cfg.LineNumbers {
+	e.renderANSIBackgrounds(textZeroLocation)
+	for idx, line := range e.visibleLines {
+		if e.cfg.LineNumbers && (idx == 0 || e.visibleLines[idx-1].Index != line.Index) {
 			rl.DrawTextEx(e.parent.Font,
 				fmt.Sprintf("%d", line.ActualLine),
 				rl.Vector2{X: textZeroLocation.X, Y: textZeroLocation.Y + float32(idx)*charSize.Y},
@@ -1007,24 +1078,16 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 		}
 		e.renderTextRange(textZeroLocation, line.startIndex, line.endIndex, maxH, maxW, e.cfg.CurrentThemeColors().Foreground.ToColorRGBA())
 	}
-	if e.cfg.EnableSyntaxHighlighting {
-		if len(e.bufferLines) > 0 {
-			visibleStartChar := e.bufferLines[e.VisibleStart].startIndex
-
-			var visibleEndChar int
-			if len(e.bufferLines) > int(e.VisibleEnd()) {
-				visibleEndChar = e.bufferLines[e.VisibleEnd()].endIndex
-			} else {
-				visibleEndChar = len(e.Buffer.Content)
-			}
-
-			for _, h := range e.Buffer.highlights {
-				if visibleStartChar <= h.start && visibleEndChar >= h.end {
-					e.renderTextRange(textZeroLocation, h.start, h.end, maxH, maxW, h.Color)
-				}
+	if e.cfg.EnableSyntaxHighlighting && len(e.visibleLines) > 0 {
+		visibleStartChar := e.visibleLines[0].startIndex
+		visibleEndChar := e.visibleLines[len(e.visibleLines)-1].endIndex
+		for _, h := range e.Buffer.highlights {
+			if h.start < visibleEndChar && h.end > visibleStartChar {
+				e.renderTextRange(textZeroLocation, h.start, h.end, maxH, maxW, h.Color)
 			}
 		}
 	}
+	e.renderANSIForegrounds(textZeroLocation, maxH, maxW)
 
 	// render cursors
 	cursorBlinkDeltaTime := time.Since(e.lastCursorTime)
@@ -1037,55 +1100,49 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 		e.showCursors = true
 	}
 
+	e.renderDiagnostics(textZeroLocation)
+	if e.Locations != nil {
+		e.underlineLocations(textZeroLocation, e.cfg.CurrentThemeColors().Foreground.ToColorRGBA())
+	}
+	if e.Locations != nil && e.Locations.Current >= 0 {
+		if row, _, visible := e.screenPosition(e.Buffer.Lines().LineStart(e.Locations.Current)); visible {
+			_, posY := e.cellPosition(textZeroLocation, row, 0)
+			rl.DrawRectangle(int32(textZeroLocation.X), posY, e.maxColumn*int32(charSize.X), int32(charSize.Y), rl.Fade(e.cfg.CurrentThemeColors().SelectionBackground.ToColorRGBA(), 0.3))
+		}
+	}
+
 	if e.parent.ActiveDrawableID() == e.ID {
 		if e.Cursor.Start() == e.Cursor.End() {
-			cursor := e.BufferIndexToPosition(e.Cursor.Start())
-			cursorView := Position{
-				Line:   cursor.Line - int(e.VisibleStart),
-				Column: cursor.Column,
-			}
-			posX := int32(cursorView.Column)*int32(charSize.X) + int32(textZeroLocation.X)
-			if e.cfg.LineNumbers {
-				posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
-			}
-			posY := int32(cursorView.Line)*int32(charSize.Y) + int32(textZeroLocation.Y)
-
-			// if !isVisibleInWindow(float64(posX), float64(posY), zeroLocation, maxH, maxW) {
-			//	 continue
-			// }
-			if e.showCursors {
+			row, col, visible := e.screenPosition(e.Cursor.Start())
+			posX, posY := e.cellPosition(textZeroLocation, row, col)
+			if visible && e.showCursors {
 				switch e.cfg.CursorShape {
 				case CURSOR_SHAPE_OUTLINE:
 					rl.DrawRectangleLines(posX, posY, int32(charSize.X), int32(charSize.Y), e.cfg.CurrentThemeColors().Cursor.ToColorRGBA())
 				case CURSOR_SHAPE_BLOCK:
-					rl.DrawRectangle(posX, posY, int32(charSize.X), int32(charSize.Y), e.cfg.CurrentThemeColors().Cursor.ToColorRGBA())
-					if len(e.Buffer.Content)-1 >= e.Cursor.Point {
-						rl.DrawTextEx(e.parent.Font, string(e.Buffer.Content[e.Cursor.Point]), rl.Vector2{X: float32(posX), Y: float32(posY)}, float32(e.parent.FontSize), 0, e.cfg.CurrentThemeColors().Background.ToColorRGBA())
+					under := e.Buffer.Content.Slice(e.Cursor.Point, e.nextRune(e.Cursor.Point))
+					width := int32(1)
+					if r, _ := utf8.DecodeRune(under); len(under) > 0 && r != '\n' {
+						width = int32(byteutils.CellWidth(r, col, e.tabWidth()))
+					}
+					rl.DrawRectangle(posX, posY, width*int32(charSize.X), int32(charSize.Y), e.cfg.CurrentThemeColors().Cursor.ToColorRGBA())
+					if len(under) > 0 && under[0] != '\n' {
+						e.drawCells(posX, posY, col, under, e.cfg.CurrentThemeColors().Background.ToColorRGBA())
 					}
 
 				case CURSOR_SHAPE_LINE:
 					rl.DrawRectangleLines(posX, posY, 2, int32(charSize.Y), e.cfg.CurrentThemeColors().Cursor.ToColorRGBA())
 				}
 			}
-			if e.cfg.CursorLineHighlight {
-				rl.DrawRectangle(int32(textZeroLocation.X), int32(cursorView.Line)*int32(charSize.Y)+int32(textZeroLocation.Y), e.maxColumn*int32(charSize.X), int32(charSize.Y), rl.Fade(e.cfg.CurrentThemeColors().CursorLineBackground.ToColorRGBA(), 0.15))
+			if visible && e.cfg.CursorLineHighlight {
+				rl.DrawRectangle(int32(textZeroLocation.X), posY, e.maxColumn*int32(charSize.X), int32(charSize.Y), rl.Fade(e.cfg.CurrentThemeColors().CursorLineBackground.ToColorRGBA(), 0.15))
 			}
 
 			// highlight matching char
-			if e.cfg.HighlightMatchingParen {
-				matchingIdx := byteutils.FindMatching(e.Buffer.Content, e.Cursor.Point)
-				if matchingIdx != -1 {
-					idxPosition := e.BufferIndexToPosition(matchingIdx)
-					idxPositionView := Position{
-						Line:   idxPosition.Line - int(e.VisibleStart),
-						Column: idxPosition.Column,
-					}
-					posX := int32(idxPositionView.Column)*int32(charSize.X) + int32(textZeroLocation.X)
-					if e.cfg.LineNumbers {
-						posX += int32(e.getLineNumbersMaxLength()) * int32(charSize.X)
-					}
-					posY := int32(idxPositionView.Line)*int32(charSize.Y) + int32(textZeroLocation.Y)
-
+			if e.cfg.HighlightMatchingParen && e.Cursor.Point < e.Buffer.Content.Len() && isBracket(e.Buffer.Content.At(e.Cursor.Point)) {
+				matchingIdx := e.seekAround(e.Cursor.Point, matchingParenDistance, byteutils.FindMatching)
+				if row, col, ok := e.screenPosition(matchingIdx); matchingIdx != -1 && ok {
+					posX, posY := e.cellPosition(textZeroLocation, row, col)
 					rl.DrawRectangle(posX, posY, int32(charSize.X), int32(charSize.Y), rl.Fade(e.cfg.CurrentThemeColors().HighlightMatching.ToColorRGBA(), 0.4))
 				}
 			}
@@ -1099,43 +1156,22 @@ func (e *BufferView) Render(zeroLocation rl.Vector2, maxH float64, maxW float64)
 	e.textZeroLocation = textZeroLocation
 }
 
-func (e *BufferView) isValidCursorPosition(newPosition Position) bool {
-	if newPosition.Line < 0 {
-		return false
-	}
-	if len(e.bufferLines) == 0 && newPosition.Line == 0 && newPosition.Column >= 0 && int32(newPosition.Column) < e.maxColumn-int32(len(fmt.Sprint(newPosition.Line)))-1 {
-		return true
-	}
-	if newPosition.Line >= len(e.bufferLines) && (len(e.bufferLines) != 0) {
-		return false
-	}
-
-	if newPosition.Column < 0 {
-		return false
-	}
-	if newPosition.Column > e.bufferLines[newPosition.Line].Length+1 {
-		return false
-	}
-
-	return true
-}
-
 func (e *BufferView) deleteSelectionsIfAnySelection() {
 	if e.Buffer.Readonly {
 		return
 	}
-	old := len(e.Buffer.Content)
 	if e.Cursor.Start() == e.Cursor.End() {
 		return
 	}
+	start, end := e.Cursor.Start(), e.nextRune(e.Cursor.End())
+	deleted := e.Buffer.Delete(start, end)
 	e.AddBufferAction(BufferAction{
 		Type: BufferActionType_Delete,
-		Idx:  e.Cursor.Start(),
-		Data: e.Buffer.Content[e.Cursor.Start() : e.Cursor.End()+1],
+		Idx:  start,
+		Data: deleted,
 	})
-	e.Buffer.Content = append(e.Buffer.Content[:e.Cursor.Start()], e.Buffer.Content[e.Cursor.End()+1:]...)
-	e.Cursor.Point = e.Cursor.Mark
-	PointLeft(e, old-1-len(e.Buffer.Content))
+	e.Cursor.SetBoth(start)
+	e.ScrollIfNeeded()
 }
 
 func (e *BufferView) ScrollIfNeeded() {
@@ -1149,8 +1185,8 @@ func (e *BufferView) ScrollIfNeeded() {
 		e.VisibleStart += e.maxLine / 3
 	}
 
-	if int(e.VisibleEnd()) >= len(e.bufferLines) {
-		e.VisibleStart = int32(len(e.bufferLines)-1) - e.maxLine
+	if int(e.VisibleEnd()) >= e.Buffer.Lines().LineCount() {
+		e.VisibleStart = int32(e.Buffer.Lines().LineCount()-1) - e.maxLine
 	}
 
 	if e.VisibleStart < 0 {
@@ -1159,30 +1195,48 @@ func (e *BufferView) ScrollIfNeeded() {
 }
 
 func (e *BufferView) readFileFromDisk() error {
+	if e.Buffer.Large {
+		// mapped again instead of read, content of large buffers can't be replaced by edits.
+		info, err := os.Stat(e.Buffer.File)
+		if err != nil {
+			return err
+		}
+		e.Search.stopStream()
+		if err := e.parent.mapLargeFile(e.Buffer, info.Size()); err != nil {
+			return err
+		}
+		e.Cursor.Point = min(e.Cursor.Point, e.Buffer.Content.Len())
+		e.Cursor.Mark = min(e.Cursor.Mark, e.Buffer.Content.Len())
+		e.SetStateClean()
+		return nil
+	}
 	bs, err := os.ReadFile(e.Buffer.File)
 	if err != nil {
-		return nil
+		return err
+	}
+	if info, err := os.Stat(e.Buffer.File); err == nil {
+		e.Buffer.DiskState = newFileState(info, bs)
 	}
+	e.Buffer.conflict = FileState{}
 
 	//replace CRLF with LF
 	if bytes.Index(bs, []byte("\r\n")) != -1 {
 		bs = bytes.Replace(bs, []byte("\r"), []byte(""), -1)
 		e.Buffer.CRLF = true
 	}
-	e.Buffer.Content = bs
-	e.replaceTabsWithSpaces()
+	e.ReplaceContent(bs)
 	e.SetStateClean()
 	return nil
 }
 
 // Things that change buffer content
 
-func BufferInsertChar(e *BufferView, char byte) {
+func BufferInsertChar(e *BufferView, char rune) {
 	if e.Buffer.Readonly {
 		return
 	}
 	e.deleteSelectionsIfAnySelection()
-	e.AddBytesAtIndex([]byte{char}, e.Cursor.Point, true)
+	e.AddBytesAtIndex(utf8.AppendRune(nil, char), e.Cursor.Point, true)
 	PointRight(e, 1)
 	e.SetStateDirty()
 	e.ScrollIfNeeded()
@@ -1194,8 +1248,13 @@ func DeleteCharBackward(e *BufferView) error {
 		return nil
 	}
 	e.deleteSelectionsIfAnySelection()
-	e.RemoveRange(e.Cursor.Point-1, e.Cursor.Point, true)
-	PointLeft(e, 1)
+	if e.Cursor.Point <= 0 {
+		return nil
+	}
+	prev := e.prevRune(e.Cursor.Point)
+	e.RemoveRange(prev, e.Cursor.Point, true)
+	e.Cursor.SetBoth(prev)
+	e.ScrollIfNeeded()
 	e.SetStateDirty()
 	return nil
 }
@@ -1205,39 +1264,105 @@ func DeleteCharForward(e *BufferView) error {
 		return nil
 	}
 	e.deleteSelectionsIfAnySelection()
-	e.RemoveRange(e.Cursor.Point, e.Cursor.Point+1, true)
+	e.RemoveRange(e.Cursor.Point, e.nextRune(e.Cursor.Point), true)
 
 	e.SetStateDirty()
 	return nil
 }
-func RevertLastBufferAction(e *BufferView) {
-	last, err := e.ActionStack.Pop()
-	if err != nil {
-		if errors.Is(err, EmptyStack) {
-			e.SetStateClean()
+func (e *BufferView) applyUndoNode(n *UndoNode, revert bool) {
+	if revert {
+		for i := len(n.Actions) - 1; i >= 0; i-- {
+			a := n.Actions[i]
+			switch a.Type {
+			case BufferActionType_Insert:
+				e.RemoveRange(a.Idx, a.Idx+len(a.Data), false)
+			case BufferActionType_Delete:
+				e.AddBytesAtIndex(a.Data, a.Idx, false)
+			}
 		}
-		return
+		e.Cursor = n.CursorBefore
+	} else {
+		for _, a := range n.Actions {
+			switch a.Type {
+			case BufferActionType_Insert:
+				e.AddBytesAtIndex(a.Data, a.Idx, false)
+			case BufferActionType_Delete:
+				e.RemoveRange(a.Idx, a.Idx+len(a.Data), false)
+			}
+		}
+		e.Cursor = n.CursorAfter
 	}
-	switch last.Type {
-	case BufferActionType_Insert:
-		e.RemoveRange(last.Idx, last.Idx+len(last.Data), false)
-	case BufferActionType_Delete:
-		e.AddBytesAtIndex(last.Data, last.Idx, false)
+	e.Cursor.Point = min(max(e.Cursor.Point, 0), e.Buffer.Content.Len())
+	e.Cursor.Mark = min(max(e.Cursor.Mark, 0), e.Buffer.Content.Len())
+	if e.Buffer.History().IsSaved() {
+		e.SetStateClean()
+	} else {
+		e.SetStateDirty()
 	}
-	e.SetStateDirty()
+	e.ScrollIfNeeded()
+}
 
-	if len(e.ActionStack.data) < 1 {
-		e.SetStateClean()
+func RevertLastBufferAction(e *BufferView) {
+	if n := e.Buffer.History().Undo(); n != nil {
+		e.applyUndoNode(n, true)
+	}
+}
+
+func RedoLastBufferAction(e *BufferView) {
+	if n := e.Buffer.History().Redo(); n != nil {
+		e.applyUndoNode(n, false)
+	}
+}
+
+// UndoTo undoes and redoes edits until buffer is in the state right after target was applied.
+func (e *BufferView) UndoTo(target *UndoNode) {
+	history := e.Buffer.History()
+	undos, redos := history.PathTo(target)
+	for range undos {
+		e.applyUndoNode(history.Undo(), true)
+	}
+	for _, n := range redos {
+		history.SelectRedo(n)
+		e.applyUndoNode(history.Redo(), false)
+	}
+}
+
+const (
+	seekWindow            = 4 << 10
+	matchingParenDistance = 100 << 10 // how far matching paren of the one under cursor is looked for
+)
+
+// seekAround runs f on content around idx instead of the whole buffer, f gets a slice and idx inside it.
+// Window grows while result is -1 or near its edges so cost depends on how far the result is, not on size
+// of the buffer. limit is the most bytes looked at on each side, 0 means no limit.
+func (e *BufferView) seekAround(idx int, limit int, f func(bs []byte, idx int) int) int {
+	size := e.Buffer.Content.Len()
+	for w := seekWindow; ; w *= 2 {
+		if limit > 0 {
+			w = min(w, limit)
+		}
+		end := min(max(idx+w, 0), size)
+		start := min(max(idx-w, 0), end)
+		r := f(e.Buffer.Content.Slice(start, end), idx-start)
+		// a rune cut by window start looks like a non letter.
+		atStart := start > 0 && (r == -1 || r < utf8.UTFMax)
+		atEnd := end < size && (r == -1 || r >= end-start-utf8.UTFMax)
+		if (!atStart && !atEnd) || w == limit {
+			if r == -1 {
+				return -1
+			}
+			return start + r
+		}
 	}
 }
 
 func WordAtPoint(e *BufferView) (int, int) {
-	currentWordStart := byteutils.SeekPreviousNonLetter(e.Buffer.Content, e.Cursor.Point)
+	currentWordStart := e.seekAround(e.Cursor.Point, 0, byteutils.SeekPreviousNonLetter)
 	if currentWordStart != 0 {
 		currentWordStart++
 	}
-	currentWordEnd := byteutils.SeekNextNonLetter(e.Buffer.Content, e.Cursor.Point)
-	if currentWordEnd != len(e.Buffer.Content)-1 {
+	currentWordEnd := e.seekAround(e.Cursor.Point, 0, byteutils.SeekNextNonLetter)
+	if currentWordEnd != e.Buffer.Content.Len()-1 {
 		currentWordEnd--
 	}
 
@@ -1245,15 +1370,15 @@ func WordAtPoint(e *BufferView) (int, int) {
 }
 
 func LeftWord(e *BufferView) (int, int) {
-	leftWordEnd := byteutils.SeekPreviousNonLetter(e.Buffer.Content, e.Cursor.Point)
-	leftWordStart := byteutils.SeekPreviousNonLetter(e.Buffer.Content, leftWordEnd-1) + 1
+	leftWordEnd := e.seekAround(e.Cursor.Point, 0, byteutils.SeekPreviousNonLetter)
+	leftWordStart := e.seekAround(leftWordEnd-1, 0, byteutils.SeekPreviousNonLetter) + 1
 
 	return leftWordStart, leftWordEnd
 }
 
 func RightWord(e *BufferView) (int, int) {
-	rightWordStart := byteutils.SeekNextNonLetter(e.Buffer.Content, e.Cursor.Point) + 1
-	rightWordEnd := byteutils.SeekNextNonLetter(e.Buffer.Content, rightWordStart)
+	rightWordStart := e.seekAround(e.Cursor.Point, 0, byteutils.SeekNextNonLetter) + 1
+	rightWordEnd := e.seekAround(rightWordStart, 0, byteutils.SeekNextNonLetter)
 	return rightWordStart, rightWordEnd
 }
 
@@ -1265,9 +1390,9 @@ func DeleteWordBackward(e *BufferView) {
 	if leftWordStart == -1 || leftWordEnd == -1 {
 		return
 	}
-	old := len(e.Buffer.Content)
+	old := e.Buffer.Content.Len()
 	e.RemoveRange(leftWordStart, e.Cursor.Point, true)
-	e.Cursor.SetBoth(e.Cursor.Point + (len(e.Buffer.Content) - old))
+	e.Cursor.SetBoth(e.Cursor.Point + (e.Buffer.Content.Len() - old))
 
 	e.SetStateDirty()
 }
@@ -1285,11 +1410,13 @@ func KillLine(e *BufferView) {
 		return
 	}
 	var lastChange int
-	old := len(e.Buffer.Content)
+	old := e.Buffer.Content.Len()
 	PointLeft(e, lastChange)
 	line := e.getBufferLineForIndex(e.Cursor.Start())
+	e.BeginUndoGroup()
+	defer e.EndUndoGroup()
 	e.RemoveRange(e.Cursor.Point, line.endIndex, true)
-	lastChange += -1 * (len(e.Buffer.Content) - old)
+	lastChange += -1 * (e.Buffer.Content.Len() - old)
 	e.SetStateDirty()
 
 }
@@ -1297,14 +1424,16 @@ func Cut(e *BufferView) error {
 	if e.Buffer.Readonly {
 		return nil
 	}
+	e.BeginUndoGroup()
+	defer e.EndUndoGroup()
 	if e.Cursor.Start() != e.Cursor.End() {
 		// Copy selection
-		WriteToClipboard(e.Buffer.Content[e.Cursor.Start() : e.Cursor.End()+1])
-		e.RemoveRange(e.Cursor.Start(), e.Cursor.End()+1, true)
+		WriteToClipboard(e.Buffer.Content.Slice(e.Cursor.Start(), e.nextRune(e.Cursor.End())))
+		e.RemoveRange(e.Cursor.Start(), e.nextRune(e.Cursor.End()), true)
 		e.Cursor.Mark = e.Cursor.Point
 	} else {
 		line := e.getBufferLineForIndex(e.Cursor.Start())
-		WriteToClipboard(e.Buffer.Content[line.startIndex : line.endIndex+1])
+		WriteToClipboard(e.Buffer.Content.Slice(line.startIndex, line.endIndex+1))
 		e.RemoveRange(line.startIndex, line.endIndex+1, true)
 	}
 	e.SetStateDirty()
@@ -1317,9 +1446,12 @@ func Paste(e *BufferView) error {
 		return nil
 	}
 	contentToPaste := GetClipboardContent()
+	e.BeginUndoGroup()
+	defer e.EndUndoGroup()
 	e.AddBytesAtIndex(contentToPaste, e.Cursor.Start(), true)
 	e.SetStateDirty()
-	PointRight(e, len(contentToPaste))
+	e.Cursor.SetBoth(e.Cursor.Start() + len(contentToPaste))
+	e.ScrollIfNeeded()
 	return nil
 }
 
@@ -1330,11 +1462,9 @@ func InteractiveGotoLine(e *BufferView) {
 			return
 		}
 
-		for _, line := range e.bufferLines {
-			if line.ActualLine == number {
-				e.Cursor.SetBoth(line.startIndex)
-				e.ScrollIfNeeded()
-			}
+		if number >= 1 && number <= e.Buffer.Lines().LineCount() {
+			e.Cursor.SetBoth(e.Buffer.Lines().LineStart(number - 1))
+			e.ScrollIfNeeded()
 		}
 
 		return
@@ -1368,19 +1498,23 @@ func ScrollToTop(e *BufferView) {
 }
 
 func ScrollToBottom(e *BufferView) {
-	e.VisibleStart = int32(len(e.bufferLines) - 1 - int(e.maxLine))
-	e.Cursor.SetBoth(e.bufferLines[len(e.bufferLines)-1].startIndex)
+	lastLine := e.Buffer.Lines().LineCount() - 1
+	e.VisibleStart = int32(lastLine - int(e.maxLine))
+	if e.VisibleStart < 0 {
+		e.VisibleStart = 0
+	}
+	e.Cursor.SetBoth(e.Buffer.Lines().LineStart(lastLine))
 
 	return
 }
 
 func ScrollDown(e *BufferView, n int) error {
-	if int(e.VisibleEnd()) >= len(e.bufferLines) {
+	if int(e.VisibleEnd()) >= e.Buffer.Lines().LineCount() {
 		return nil
 	}
 	e.VisibleStart += int32(n)
-	if int(e.VisibleEnd()) >= len(e.bufferLines) {
-		e.VisibleStart = int32(len(e.bufferLines)-1) - e.maxLine
+	if int(e.VisibleEnd()) >= e.Buffer.Lines().LineCount() {
+		e.VisibleStart = int32(e.Buffer.Lines().LineCount()-1) - e.maxLine
 	}
 
 	return nil
@@ -1390,7 +1524,9 @@ func ScrollDown(e *BufferView, n int) error {
 // @Point
 
 func PointLeft(e *BufferView, n int) error {
-	e.Cursor.Point -= n
+	for i := 0; i < n; i++ {
+		e.Cursor.Point = e.prevRune(e.Cursor.Point)
+	}
 	if e.Cursor.Point < 0 {
 		e.Cursor.SetBoth(0)
 	}
@@ -1400,8 +1536,10 @@ func PointLeft(e *BufferView, n int) error {
 }
 
 func PointRight(e *BufferView, n int) error {
-	e.Cursor.Point += n
-	if e.Cursor.Point > len(e.Buffer.Content) {
+	for i := 0; i < n; i++ {
+		e.Cursor.Point = e.nextRune(e.Cursor.Point)
+	}
+	if e.Cursor.Point > e.Buffer.Content.Len() {
 		e.Cursor.SetBoth(0)
 	}
 	e.Cursor.Mark = e.Cursor.Point
@@ -1416,13 +1554,9 @@ func PointUp(e *BufferView) error {
 		return nil
 	}
 
-	prevLine := e.bufferLines[prevLineIndex]
-	col := e.Cursor.Point - currentLine.startIndex
-	newidx := prevLine.startIndex + col
-	if newidx > prevLine.endIndex {
-		newidx = prevLine.endIndex
-	}
-	e.Cursor.SetBoth(newidx)
+	prevLine := e.bufferLine(prevLineIndex)
+	col := e.lineColumn(currentLine, e.Cursor.Point)
+	e.Cursor.SetBoth(e.columnToIndex(prevLine, col))
 	e.ScrollIfNeeded()
 
 	return nil
@@ -1431,24 +1565,20 @@ func PointUp(e *BufferView) error {
 func PointDown(e *BufferView) error {
 	currentLine := e.getBufferLineForIndex(e.Cursor.Point)
 	nextLineIndex := currentLine.Index + 1
-	if nextLineIndex >= len(e.bufferLines) {
+	if nextLineIndex >= e.Buffer.Lines().LineCount() {
 		return nil
 	}
 
-	nextLine := e.bufferLines[nextLineIndex]
-	col := e.Cursor.Point - currentLine.startIndex
-	newIndex := nextLine.startIndex + col
-	if newIndex > nextLine.endIndex {
-		newIndex = nextLine.endIndex
-	}
-	e.Cursor.SetBoth(newIndex)
+	nextLine := e.bufferLine(nextLineIndex)
+	col := e.lineColumn(currentLine, e.Cursor.Point)
+	e.Cursor.SetBoth(e.columnToIndex(nextLine, col))
 	e.ScrollIfNeeded()
 
 	return nil
 }
 
 func CentralizePoint(e *BufferView) {
-	pos := e.convertBufferIndexToLineAndColumn(e.Cursor.Start())
+	pos := e.BufferIndexToPosition(e.Cursor.Start())
 	e.VisibleStart = int32(pos.Line) - (e.maxLine / 2)
 	if e.VisibleStart < 0 {
 		e.VisibleStart = 0
@@ -1468,7 +1598,7 @@ func PointToEndOfLine(e *BufferView) error {
 }
 
 func PointToMatchingChar(e *BufferView) error {
-	matching := byteutils.FindMatching(e.Buffer.Content, e.Cursor.Point)
+	matching := e.seekAround(e.Cursor.Point, 0, byteutils.FindMatching)
 	if matching != -1 {
 		e.Cursor.SetBoth(matching)
 	}
@@ -1480,15 +1610,19 @@ func PointToMatchingChar(e *BufferView) error {
 // @Mark
 
 func MarkRight(e *BufferView, n int) {
-	e.Cursor.Mark += n
-	if e.Cursor.Mark >= len(e.Buffer.Content) {
-		e.Cursor.Mark = len(e.Buffer.Content)
+	for i := 0; i < n; i++ {
+		e.Cursor.Mark = e.nextRune(e.Cursor.Mark)
+	}
+	if e.Cursor.Mark >= e.Buffer.Content.Len() {
+		e.Cursor.Mark = e.Buffer.Content.Len()
 	}
 	e.ScrollIfNeeded()
 }
 
 func MarkLeft(e *BufferView, n int) {
-	e.Cursor.Mark -= n
+	for i := 0; i < n; i++ {
+		e.Cursor.Mark = e.prevRune(e.Cursor.Mark)
+	}
 	if e.Cursor.Mark < 0 {
 		e.Cursor.Mark = 0
 	}
@@ -1498,11 +1632,11 @@ func MarkLeft(e *BufferView, n int) {
 func MarkUp(e *BufferView, n int) {
 	currentLine := e.getBufferLineForIndex(e.Cursor.Mark)
 	nextLineIndex := currentLine.Index - n
-	if nextLineIndex >= len(e.bufferLines) || nextLineIndex < 0 {
+	if nextLineIndex >= e.Buffer.Lines().LineCount() || nextLineIndex < 0 {
 		return
 	}
 
-	nextLine := e.bufferLines[nextLineIndex]
+	nextLine := e.bufferLine(nextLineIndex)
 	newcol := nextLine.startIndex
 	e.Cursor.Mark = newcol
 	e.ScrollIfNeeded()
@@ -1511,11 +1645,11 @@ func MarkUp(e *BufferView, n int) {
 func MarkDown(e *BufferView, n int) {
 	currentLine := e.getBufferLineForIndex(e.Cursor.Mark)
 	nextLineIndex := currentLine.Index + n
-	if nextLineIndex >= len(e.bufferLines) {
+	if nextLineIndex >= e.Buffer.Lines().LineCount() {
 		return
 	}
 
-	nextLine := e.bufferLines[nextLineIndex]
+	nextLine := e.bufferLine(nextLineIndex)
 	newcol := nextLine.startIndex
 	e.Cursor.Mark = newcol
 	e.ScrollIfNeeded()
@@ -1524,7 +1658,7 @@ func MarkDown(e *BufferView, n int) {
 }
 
 func MarkPreviousWord(e *BufferView) {
-	j := byteutils.SeekPreviousNonLetter(e.Buffer.Content, e.Cursor.Mark)
+	j := e.seekAround(e.Cursor.Mark, 0, byteutils.SeekPreviousNonLetter)
 	if j != -1 {
 		e.Cursor.Mark = j
 		e.ScrollIfNeeded()
@@ -1534,7 +1668,7 @@ func MarkPreviousWord(e *BufferView) {
 }
 
 func MarkNextWord(e *BufferView) {
-	j := byteutils.SeekNextNonLetter(e.Buffer.Content, e.Cursor.Mark)
+	j := e.seekAround(e.Cursor.Mark, 0, byteutils.SeekNextNonLetter)
 	if j != -1 {
 		e.Cursor.Mark = j
 	}
@@ -1554,7 +1688,7 @@ func MarkToBeginningOfLine(e *BufferView) {
 	e.Cursor.Mark = line.startIndex
 }
 func MarkToMatchingChar(e *BufferView) {
-	matching := byteutils.FindMatching(e.Buffer.Content, e.Cursor.Point)
+	matching := e.seekAround(e.Cursor.Point, 0, byteutils.FindMatching)
 	if matching != -1 {
 		e.Cursor.Mark = matching
 	}
@@ -1565,7 +1699,7 @@ func MarkToMatchingChar(e *BufferView) {
 
 func PointRightWord(e *BufferView) {
 	e.Cursor.SetBoth(e.Cursor.Point)
-	j := byteutils.SeekNextNonLetter(e.Buffer.Content, e.Cursor.Point)
+	j := e.seekAround(e.Cursor.Point, 0, byteutils.SeekNextNonLetter)
 	if j != -1 {
 		e.Cursor.SetBoth(j)
 	}
@@ -1576,7 +1710,7 @@ func PointRightWord(e *BufferView) {
 
 func PointLeftWord(e *BufferView) {
 	e.Cursor.SetBoth(e.Cursor.Point)
-	j := byteutils.SeekPreviousNonLetter(e.Buffer.Content, e.Cursor.Point)
+	j := e.seekAround(e.Cursor.Point, 0, byteutils.SeekPreviousNonLetter)
 	if j != -1 {
 		e.Cursor.SetBoth(j)
 	}
@@ -1588,45 +1722,126 @@ func Write(e *BufferView) {
 		return
 	}
 
-	if e.Buffer.fileType.TabSize != 0 {
-		e.Buffer.Content = bytes.Replace(e.Buffer.Content, []byte(strings.Repeat(" ", e.Buffer.fileType.TabSize)), []byte("\t"), -1)
+	if err := e.Save(); err != nil {
+		e.parent.ShowMessage(fmt.Sprintf("Error saving %s: %s", e.Buffer.File, err))
+		if errors.Is(err, ErrFileChangedOnDisk) {
+			e.parent.FileConflictPrompt(e)
+		}
+		return
+	}
+	e.parent.RemoveJournal(e.Buffer)
+	for _, hook := range e.parent.SaveHooks {
+		hook(e)
 	}
+}
 
+// Save writes buffer to its file, errors from BeforeSave don't stop the save but are returned along
+// with any other error. If file was changed by another program since we loaded it nothing is written
+// and ErrFileChangedOnDisk is returned.
+func (e *BufferView) Save() error {
+	if e.Buffer.Large {
+		return ErrLargeFile
+	}
+	if !e.Buffer.DiskState.IsZero() {
+		state, err := ReadFileState(e.Buffer.File, e.Buffer.DiskState)
+		if err == nil && state.Hash != e.Buffer.DiskState.Hash {
+			return ErrFileChangedOnDisk
+		}
+	}
+
+	var errs []error
 	if e.Buffer.fileType.BeforeSave != nil {
-		_ = e.Buffer.fileType.BeforeSave(e)
+		e.BeginUndoGroup()
+		if err := e.Buffer.fileType.BeforeSave(e); err != nil {
+			errs = append(errs, fmt.Errorf("before save: %w", err))
+		}
+		e.EndUndoGroup()
 	}
 
+	content := e.Buffer.Content.Bytes()
 	if e.Buffer.CRLF {
-		e.Buffer.Content = bytes.Replace(e.Buffer.Content, []byte("\n"), []byte("\r\n"), -1)
+		content = bytes.Replace(content, []byte("\n"), []byte("\r\n"), -1)
 	}
 
-	if err := os.WriteFile(e.Buffer.File, e.Buffer.Content, 0644); err != nil {
-		return
+	if err := writeFileAtomic(e.Buffer.File, content, e.cfg != nil && e.cfg.BackupFiles); err != nil {
+		return errors.Join(append(errs, err)...)
 	}
-	e.SetStateClean()
-	e.replaceTabsWithSpaces()
-	if e.Buffer.CRLF {
-		e.Buffer.Content = bytes.Replace(e.Buffer.Content, []byte("\r\n"), []byte("\n"), -1)
+	if info, err := os.Stat(e.Buffer.File); err == nil {
+		e.Buffer.DiskState = newFileState(info, content)
 	}
+	e.Buffer.conflict = FileState{}
+	e.SetStateClean()
 	if e.Buffer.fileType.AfterSave != nil {
-		_ = e.Buffer.fileType.AfterSave(e)
+		if err := e.Buffer.fileType.AfterSave(e); err != nil {
+			errs = append(errs, fmt.Errorf("after save: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
 
+// writeFileAtomic writes content to a temporary file next to path and renames it over path, so path
+// either has old or new content even if we crash in the middle. Permissions of existing file are kept,
+// if backup is set old content is also kept in path~.
+func writeFileAtomic(path string, content []byte, backup bool) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		mode = info.Mode().Perm()
+		if backup {
+			old, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path+"~", old, mode); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
 	}
 
-	return
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// make the rename durable, not every platform can sync a directory so errors are ignored.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+	return nil
 }
 
 func Copy(e *BufferView) error {
 	if e.Cursor.Start() != e.Cursor.End() {
 		// Copy selection
-		end := e.Cursor.End() + 1
-		if end >= len(e.Buffer.Content) {
-			end = len(e.Buffer.Content) - 1
-		}
-		WriteToClipboard(e.Buffer.Content[e.Cursor.Start():end])
+		WriteToClipboard(e.Buffer.Content.Slice(e.Cursor.Start(), e.nextRune(e.Cursor.End())))
 	} else {
 		line := e.getBufferLineForIndex(e.Cursor.Start())
-		WriteToClipboard(e.Buffer.Content[line.startIndex : line.endIndex+1])
+		WriteToClipboard(e.Buffer.Content.Slice(line.startIndex, line.endIndex+1))
 	}
 
 	return nil
@@ -1657,23 +1872,142 @@ func CompileNoAsk(a *BufferView) {
 	return
 }
 
+// GrepAsk asks for a pattern to grep, search options, globs and scope of the previous grep are kept. Besides
+// search option toggles Alt-d changes searched directory, Alt-g edits globs and <up>/<down> recall previous
+// searches.
 func GrepAsk(a *BufferView) {
-	a.parent.SetPrompt("Grep", nil, func(userInput string, c *Context) {
-		if err := a.parent.OpenGrepBufferInSensibleSplit(userInput); err != nil {
+	c := a.parent
+	query := GrepQuery{Options: SearchOptions{CaseSensitive: true}}
+	if n := len(c.GrepHistory.Entries); n > 0 {
+		query = c.GrepHistory.Entries[n-1]
+		query.Pattern = ""
+	}
+	c.GrepHistory.Rewind()
+	c.grepPrompt(&query)
+}
+
+func (c *Context) grepPromptText(query *GrepQuery) string {
+	text := fmt.Sprintf("Grep [%s] in %s", query.Options, c.GrepDir(query.Scope))
+	if query.Globs != "" {
+		text += " -g " + query.Globs
+	}
+	return text
+}
+
+func (c *Context) grepPrompt(query *GrepQuery) {
+	keymap := searchPromptKeymap(PromptKeymap, "Grep", &query.Options)
+	changeHook := func(userInput string, c *Context) {
+		query.Pattern = userInput
+		c.Prompt.Text = c.grepPromptText(query)
+		c.Prompt.Error = ""
+		if _, err := compileSearchPattern(userInput, query.Options); err != nil {
+			c.Prompt.Error = err.Error()
+		}
+	}
+	recall := func(c *Context, q GrepQuery) {
+		*query = q
+		c.Prompt.UserInput = q.Pattern
+		changeHook(q.Pattern, c)
+	}
+	keymap.BindKey(Key{K: "d", Alt: true}, func(c *Context) {
+		query.Scope = (query.Scope + 1) % grepScopeCount
+		changeHook(c.Prompt.UserInput, c)
+	})
+	keymap.BindKey(Key{K: "g", Alt: true}, func(c *Context) {
+		query.Pattern = c.Prompt.UserInput
+		globKeymap := PromptKeymap.Clone()
+		globKeymap.BindKey(Key{K: "<esc>"}, func(c *Context) { c.grepPrompt(query) })
+		c.SetPrompt("Grep globs (!glob excludes)", nil, func(userInput string, c *Context) {
+			query.Globs = strings.Join(strings.Fields(userInput), " ")
+			c.grepPrompt(query)
+		}, &globKeymap, query.Globs)
+	})
+	keymap.BindKey(Key{K: "<up>"}, func(c *Context) {
+		if q, ok := c.GrepHistory.Prev(); ok {
+			recall(c, q)
+		}
+	})
+	keymap.BindKey(Key{K: "<down>"}, func(c *Context) {
+		q, ok := c.GrepHistory.Next()
+		if !ok {
+			q = *query
+			q.Pattern = ""
+		}
+		recall(c, q)
+	})
+
+	c.SetPrompt(c.grepPromptText(query), changeHook, func(userInput string, c *Context) {
+		query.Pattern = userInput
+		if _, err := compileSearchPattern(userInput, query.Options); err != nil {
+			c.grepPrompt(query)
+			c.Prompt.Error = err.Error()
 			return
 		}
+		c.GrepHistory.Add(*query)
+		_ = c.OpenGrepBufferInSensibleSplit(*query)
+	}, &keymap, query.Pattern)
+}
 
-		return
-	}, nil, "")
+const BIG_FILE_SEARCH_THRESHOLD = 1024 * 1024
 
-	return
+// searchPromptKeymap adds keys for toggling search options to a prompt keymap, changing an option
+// reruns prompt change hook with current input.
+func searchPromptKeymap(base Keymap, text string, opts *SearchOptions) Keymap {
+	keymap := base.Clone()
+	toggle := func(f func()) func(c *Context) {
+		return func(c *Context) {
+			f()
+			c.Prompt.Text = fmt.Sprintf("%s [%s]", text, opts)
+			if c.Prompt.ChangeHook != nil {
+				c.Prompt.ChangeHook(c.Prompt.UserInput, c)
+			}
+		}
+	}
+	keymap.BindKey(Key{K: "c", Alt: true}, toggle(func() { opts.CaseSensitive = !opts.CaseSensitive }))
+	keymap.BindKey(Key{K: "w", Alt: true}, toggle(func() { opts.WholeWord = !opts.WholeWord }))
+	keymap.BindKey(Key{K: "r", Alt: true}, toggle(func() { opts.Literal = !opts.Literal }))
+	return keymap
 }
 
-const BIG_FILE_SEARCH_THRESHOLD = 1024 * 1024
+func (e *BufferView) searchFor(query string, c *Context) {
+	if !e.Search.IsSearching {
+		e.Search.IsSearching = true
+		e.keymaps.Push(SearchKeymap)
+	}
+	e.Search.SearchString = query
+	e.Search.generation++
+	re, err := compileSearchPattern(query, e.Search.Options)
+	if err != nil {
+		c.Prompt.Error = err.Error()
+		e.Search.SearchMatches = nil
+		return
+	}
+	c.Prompt.Error = ""
+	if e.Buffer.Large {
+		e.Search.SearchMatches = nil
+		e.Search.CurrentMatch = 0
+		e.Search.pattern = re
+		e.Search.streamDone = false
+		e.searchStream(e.Cursor.Point)
+		return
+	}
+	generation := e.Search.generation
+	data, offset := regionContent(e.Buffer.Content.Bytes(), e.Search.Region)
+	matchPatternAsync(c, data, re, func(matches [][]int) {
+		if e.Search.generation == generation {
+			e.Search.SearchMatches = shiftMatches(matches, offset)
+			if e.Search.CurrentMatch >= len(matches) {
+				e.Search.CurrentMatch = 0
+			}
+		}
+	})
+}
 
+// SearchActivate starts searching, when there is a selection only selected text is searched.
 func SearchActivate(bufferView *BufferView) {
-	if len(bufferView.Buffer.Content) < BIG_FILE_SEARCH_THRESHOLD {
-		thisPromptKeymap := PromptKeymap.Clone()
+	bufferView.Search.Region = bufferView.selectionRegion()
+	if bufferView.Buffer.Content.Len() < BIG_FILE_SEARCH_THRESHOLD && !bufferView.Buffer.Large {
+		thisPromptKeymap := searchPromptKeymap(PromptKeymap, "ISearch", &bufferView.Search.Options)
 		thisPromptKeymap.BindKey(Key{K: "<esc>"}, func(c *Context) {
 			c.ResetPrompt()
 			SearchExit(bufferView)
@@ -1681,35 +2015,34 @@ func SearchActivate(bufferView *BufferView) {
 		thisPromptKeymap.BindKey(Key{K: "<enter>"}, func(c *Context) {
 			SearchNextMatch(bufferView)
 		})
-		bufferView.parent.SetPrompt("ISearch", func(query string, c *Context) {
-			if !bufferView.Search.IsSearching {
-				bufferView.Search.IsSearching = true
-				bufferView.keymaps.Push(SearchKeymap)
-			}
-			bufferView.Search.SearchString = query
-			matchPatternAsync(&bufferView.Search.SearchMatches, bufferView.Buffer.Content, []byte(query))
-		}, nil, &thisPromptKeymap, "")
+		bufferView.parent.SetPrompt(fmt.Sprintf("ISearch [%s]", bufferView.Search.Options), bufferView.searchFor, nil, &thisPromptKeymap, "")
 		bufferView.parent.Prompt.NoRender = true
 	} else {
-		bufferView.parent.SetPrompt("Search", nil, func(query string, c *Context) {
-			if !bufferView.Search.IsSearching {
-				bufferView.Search.IsSearching = true
-				bufferView.keymaps.Push(SearchKeymap)
+		var doneHook func(query string, c *Context)
+		thisPromptKeymap := searchPromptKeymap(PromptKeymap, "Search"+regionLabel(bufferView.Search.Region), &bufferView.Search.Options)
+		doneHook = func(query string, c *Context) {
+			if _, err := compileSearchPattern(query, bufferView.Search.Options); err != nil {
+				c.SetPrompt(fmt.Sprintf("Search%s [%s]", regionLabel(bufferView.Search.Region), bufferView.Search.Options), nil, doneHook, &thisPromptKeymap, query)
+				c.Prompt.Error = err.Error()
+				return
 			}
-			bufferView.Search.SearchString = query
-			matchPatternAsync(&bufferView.Search.SearchMatches, bufferView.Buffer.Content, []byte(query))
-		}, nil, "")
+			bufferView.searchFor(query, c)
+		}
+		bufferView.parent.SetPrompt(fmt.Sprintf("Search%s [%s]", regionLabel(bufferView.Search.Region), bufferView.Search.Options), nil, doneHook, &thisPromptKeymap, "")
 	}
 }
 
 func SearchExit(editor *BufferView) error {
-	if len(editor.Buffer.Content) < BIG_FILE_SEARCH_THRESHOLD {
+	editor.Search.stopStream()
+	editor.Search.pattern = nil
+	if editor.Buffer.Content.Len() < BIG_FILE_SEARCH_THRESHOLD && !editor.Buffer.Large {
 		editor.keymaps.Pop()
 		editor.parent.ResetPrompt()
 		editor.Search.IsSearching = false
 		editor.Search.SearchMatches = nil
 		editor.Search.CurrentMatch = 0
 		editor.Search.MovedAwayFromCurrentMatch = false
+		editor.Search.Region = nil
 		editor.parent.Prompt.NoRender = false
 		return nil
 	} else {
@@ -1717,6 +2050,7 @@ func SearchExit(editor *BufferView) error {
 		editor.Search.SearchMatches = nil
 		editor.Search.CurrentMatch = 0
 		editor.Search.MovedAwayFromCurrentMatch = false
+		editor.Search.Region = nil
 		editor.keymaps.Pop()
 	}
 
@@ -1724,6 +2058,16 @@ func SearchExit(editor *BufferView) error {
 }
 
 func SearchNextMatch(editor *BufferView) error {
+	if s := &editor.Search; s.pattern != nil && !s.streamDone && s.CurrentMatch >= len(s.SearchMatches)-1 {
+		if s.stream == nil {
+			from := editor.Cursor.Point
+			if len(s.SearchMatches) > 0 {
+				from = s.SearchMatches[len(s.SearchMatches)-1][1] + 1
+			}
+			editor.searchStream(from)
+		}
+		return nil
+	}
 	editor.Search.CurrentMatch++
 	if editor.Search.CurrentMatch >= len(editor.Search.SearchMatches) {
 		editor.Search.CurrentMatch = 0
@@ -1744,42 +2088,171 @@ func SearchPreviousMatch(editor *BufferView) error {
 	return nil
 }
 
+// QueryReplaceActivate asks for a pattern and its replacement, when there is a selection only matches inside
+// it are replaced.
 func QueryReplaceActivate(bufferView *BufferView) {
-	bufferView.parent.SetPrompt("Query", nil, func(query string, c *Context) {
-		bufferView.parent.SetPrompt("Replace", nil, func(replace string, c *Context) {
-			bufferView.QueryReplace.IsQueryReplace = true
-			bufferView.QueryReplace.SearchString = query
-			bufferView.QueryReplace.ReplaceString = replace
-			bufferView.keymaps.Push(QueryReplaceKeymap)
-			matchPatternAsync(&bufferView.QueryReplace.SearchMatches, bufferView.Buffer.Content, []byte(query))
+	if bufferView.refuseQueryReplace() {
+		return
+	}
+	var queryHook func(query string, c *Context)
+	bufferView.QueryReplace.Region = bufferView.selectionRegion()
+	text := "Query" + regionLabel(bufferView.QueryReplace.Region)
+	thisPromptKeymap := searchPromptKeymap(PromptKeymap, text, &bufferView.Search.Options)
+	queryHook = func(query string, c *Context) {
+		re, err := compileSearchPattern(query, bufferView.Search.Options)
+		if err != nil {
+			c.SetPrompt(fmt.Sprintf("%s [%s]", text, bufferView.Search.Options), nil, queryHook, &thisPromptKeymap, query)
+			c.Prompt.Error = err.Error()
+			return
+		}
+		c.SetPrompt("Replace", nil, func(replace string, c *Context) {
+			bufferView.startQueryReplace(query, replace, re)
 		}, nil, "")
-	}, nil, "")
+	}
+	bufferView.parent.SetPrompt(fmt.Sprintf("%s [%s]", text, bufferView.Search.Options), nil, queryHook, &thisPromptKeymap, "")
+}
+
+// refuseQueryReplace tells user query replace can't change a read only buffer, large files are read only too.
+func (e *BufferView) refuseQueryReplace() bool {
+	if !e.Buffer.Readonly {
+		return false
+	}
+	e.parent.ShowMessage(fmt.Sprintf("Query replace: %s is read only", e.Buffer.File))
+	return true
+}
+
+// startQueryReplace finds every match of re and what it should be replaced with, replacements are computed
+// upfront since $1/${name} references need the original match text.
+func (e *BufferView) startQueryReplace(query string, replace string, re *regexp.Regexp) {
+	if e.refuseQueryReplace() {
+		return
+	}
+	e.QueryReplace.IsQueryReplace = true
+	e.QueryReplace.SearchString = query
+	e.QueryReplace.ReplaceString = replace
+	e.keymaps.Push(QueryReplaceKeymap)
+	e.BeginUndoGroup()
+	e.QueryReplace.undoGroup = true
+	content, offset := regionContent(e.Buffer.Content.Bytes(), e.QueryReplace.Region)
+	e.QueryReplace.SearchMatches = nil
+	e.QueryReplace.Replacements = nil
+	for _, loc := range re.FindAllSubmatchIndex(content, -1) {
+		if loc[1] == loc[0] {
+			continue
+		}
+		replacement := []byte(replace)
+		if !e.Search.Options.Literal {
+			replacement = re.Expand(nil, []byte(replace), content, loc)
+		}
+		e.QueryReplace.SearchMatches = append(e.QueryReplace.SearchMatches, []int{offset + loc[0], offset + loc[1] - 1})
+		e.QueryReplace.Replacements = append(e.QueryReplace.Replacements, replacement)
+	}
+	if len(e.QueryReplace.SearchMatches) == 0 {
+		QueryReplaceExit(e)
+	}
+}
+
+// shiftQueryReplaceMatches moves every match after idx by delta bytes, used when a replacement changes length of buffer.
+func (e *BufferView) shiftQueryReplaceMatches(idx int, delta int) {
+	for _, match := range e.QueryReplace.SearchMatches[idx+1:] {
+		match[0] += delta
+		match[1] += delta
+	}
+}
+
+func (e *BufferView) replaceQueryReplaceMatch() {
+	idx := e.QueryReplace.CurrentMatch
+	match := e.QueryReplace.SearchMatches[idx]
+	replace := e.QueryReplace.Replacements[idx]
+	text := bytes.Clone(e.Buffer.Content.Slice(match[0], match[1]+1))
+	e.RemoveRange(match[0], match[1]+1, true)
+	e.AddBytesAtIndex(replace, match[0], true)
+	e.SetStateDirty()
+	e.shiftQueryReplaceMatches(idx, len(replace)-len(text))
+	e.QueryReplace.steps = append(e.QueryReplace.steps, queryReplaceStep{
+		match:    idx,
+		original: []int{match[0], match[1]},
+		text:     text,
+	})
+	e.QueryReplace.CurrentMatch++
+	e.QueryReplace.MovedAwayFromCurrentMatch = false
+}
+
+func (e *BufferView) queryReplaceDone() bool {
+	return e.QueryReplace.CurrentMatch >= len(e.QueryReplace.SearchMatches)
 }
 
 func QueryReplaceReplaceThisMatch(bufferView *BufferView) {
-	match := bufferView.QueryReplace.SearchMatches[bufferView.QueryReplace.CurrentMatch]
-	bufferView.RemoveRange(match[0], match[1]+1, false)
-	bufferView.AddBytesAtIndex([]byte(bufferView.QueryReplace.ReplaceString), match[0], false)
-	bufferView.QueryReplace.CurrentMatch++
-	bufferView.QueryReplace.MovedAwayFromCurrentMatch = false
-	if bufferView.QueryReplace.CurrentMatch >= len(bufferView.QueryReplace.SearchMatches) {
+	if bufferView.queryReplaceDone() {
+		QueryReplaceExit(bufferView)
+		return
+	}
+	bufferView.replaceQueryReplaceMatch()
+	if bufferView.queryReplaceDone() {
 		QueryReplaceExit(bufferView)
 	}
 }
+
+func QueryReplaceReplaceAndStop(bufferView *BufferView) {
+	if !bufferView.queryReplaceDone() {
+		bufferView.replaceQueryReplaceMatch()
+	}
+	QueryReplaceExit(bufferView)
+}
+
+// QueryReplaceReplaceAll replaces current and every remaining match.
+func QueryReplaceReplaceAll(bufferView *BufferView) {
+	for !bufferView.queryReplaceDone() {
+		bufferView.replaceQueryReplaceMatch()
+	}
+	QueryReplaceExit(bufferView)
+}
+
 func QueryReplaceIgnoreThisMatch(bufferView *BufferView) {
+	bufferView.QueryReplace.steps = append(bufferView.QueryReplace.steps, queryReplaceStep{match: bufferView.QueryReplace.CurrentMatch})
 	bufferView.QueryReplace.CurrentMatch++
 	bufferView.QueryReplace.MovedAwayFromCurrentMatch = false
-	if bufferView.QueryReplace.CurrentMatch >= len(bufferView.QueryReplace.SearchMatches) {
+	if bufferView.queryReplaceDone() {
 		QueryReplaceExit(bufferView)
 	}
 }
+
+// QueryReplaceBackup goes back to previous match, if it was replaced the original text is put back. This is
+// an edit inside session undo group, so undoing the session still takes one step.
+func QueryReplaceBackup(bufferView *BufferView) {
+	steps := bufferView.QueryReplace.steps
+	if len(steps) == 0 {
+		return
+	}
+	step := steps[len(steps)-1]
+	bufferView.QueryReplace.steps = steps[:len(steps)-1]
+	if step.text != nil {
+		start := step.original[0]
+		replace := bufferView.QueryReplace.Replacements[step.match]
+		bufferView.RemoveRange(start, start+len(replace), true)
+		bufferView.AddBytesAtIndex(step.text, start, true)
+		bufferView.shiftQueryReplaceMatches(step.match, len(step.text)-len(replace))
+		match := bufferView.QueryReplace.SearchMatches[step.match]
+		match[0], match[1] = step.original[0], step.original[1]
+	}
+	bufferView.QueryReplace.CurrentMatch = step.match
+	bufferView.QueryReplace.MovedAwayFromCurrentMatch = false
+}
+
 func QueryReplaceExit(bufferView *BufferView) {
 	bufferView.QueryReplace.IsQueryReplace = false
 	bufferView.QueryReplace.SearchString = ""
 	bufferView.QueryReplace.ReplaceString = ""
 	bufferView.QueryReplace.SearchMatches = nil
+	bufferView.QueryReplace.Replacements = nil
 	bufferView.QueryReplace.CurrentMatch = 0
 	bufferView.QueryReplace.MovedAwayFromCurrentMatch = false
+	bufferView.QueryReplace.steps = nil
+	bufferView.QueryReplace.Region = nil
+	if bufferView.QueryReplace.undoGroup {
+		bufferView.QueryReplace.undoGroup = false
+		bufferView.EndUndoGroup()
+	}
 	bufferView.keymaps.Pop()
 }
 
@@ -1792,5 +2265,7 @@ func WriteToClipboard(bs []byte) {
 }
 
 func RevertBuffer(bufferView *BufferView) {
-	bufferView.readFileFromDisk()
+	if err := bufferView.readFileFromDisk(); err != nil {
+		bufferView.parent.ShowMessage(fmt.Sprintf("Error reverting %s: %s", bufferView.Buffer.File, err))
+	}
 }
@@ -1,8 +1,14 @@
 package preditor
 
 import (
-	"github.com/stretchr/testify/assert"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/amirrezaask/preditor/byteutils"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSearch(t *testing.T) {
@@ -17,65 +23,62 @@ func Test_BufferInsertChar(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("12"),
+			Content: NewPieceTable([]byte("12")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 0,
 			Mark:  0,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 	BufferInsertChar(&bufferView, '0')
-	assert.Equal(t, []byte("012"), bufferView.Buffer.Content)
+	assert.Equal(t, []byte("012"), bufferView.Buffer.Content.Bytes())
 	bufferView.Cursor.SetBoth(3)
 	BufferInsertChar(&bufferView, '3')
-	assert.Equal(t, []byte("0123"), bufferView.Buffer.Content)
+	assert.Equal(t, []byte("0123"), bufferView.Buffer.Content.Bytes())
 
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, []byte("012"), bufferView.Buffer.Content)
+	assert.Equal(t, []byte("012"), bufferView.Buffer.Content.Bytes())
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, []byte("12"), bufferView.Buffer.Content)
+	assert.Equal(t, []byte("12"), bufferView.Buffer.Content.Bytes())
 }
 
 func Test_RemoveRange(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("012345678\n012345678"),
+			Content: NewPieceTable([]byte("012345678\n012345678")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 
 	bufferView.RemoveRange(3, 8, true)
-	assert.Equal(t, "0128\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "0128\n012345678", string(bufferView.Buffer.Content.Bytes()))
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 }
 
 func Test_KillLine(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("012345678\n012345678"),
+			Content: NewPieceTable([]byte("012345678\n012345678")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 	bufferView.calcRenderState()
 	KillLine(&bufferView)
-	assert.Equal(t, "01\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "01\n012345678", string(bufferView.Buffer.Content.Bytes()))
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 
 }
 
@@ -84,14 +87,13 @@ func Test_Copy(t *testing.T) {
 		bufferView := BufferView{
 			Buffer: &Buffer{
 				File:    "",
-				Content: []byte("012345678\n012345678"),
+				Content: NewPieceTable([]byte("012345678\n012345678")),
 				CRLF:    false,
 			},
 			Cursor: Cursor{
 				Point: 2,
 				Mark:  5,
 			},
-			ActionStack: NewStack[BufferAction](10),
 		}
 		Copy(&bufferView)
 		copiedValue := GetClipboardContent()
@@ -102,14 +104,13 @@ func Test_Copy(t *testing.T) {
 		bufferView := BufferView{
 			Buffer: &Buffer{
 				File:    "",
-				Content: []byte("012345678\n012345678"),
+				Content: NewPieceTable([]byte("012345678\n012345678")),
 				CRLF:    false,
 			},
 			Cursor: Cursor{
 				Point: 2,
 				Mark:  2,
 			},
-			ActionStack: NewStack[BufferAction](10),
 		}
 		bufferView.calcRenderState()
 		Copy(&bufferView)
@@ -123,39 +124,37 @@ func Test_Paste(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("01\n012345678"),
+			Content: NewPieceTable([]byte("01\n012345678")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 	WriteToClipboard([]byte("2345678"))
 	Paste(&bufferView)
-	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, "01\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "01\n012345678", string(bufferView.Buffer.Content.Bytes()))
 }
 
 func Test_DeleteCharBackward(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("012345678\n012345678"),
+			Content: NewPieceTable([]byte("012345678\n012345678")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 	DeleteCharBackward(&bufferView)
-	assert.Equal(t, "02345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "02345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 
 }
 
@@ -163,34 +162,32 @@ func Test_DeleteCharForeward(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("012345678\n012345678"),
+			Content: NewPieceTable([]byte("012345678\n012345678")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 
 	DeleteCharForward(&bufferView)
-	assert.Equal(t, "01345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "01345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 	RevertLastBufferAction(&bufferView)
-	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content))
+	assert.Equal(t, "012345678\n012345678", string(bufferView.Buffer.Content.Bytes()))
 }
 
 func Test_WordAtPoint(t *testing.T) {
 	bufferView := BufferView{
 		Buffer: &Buffer{
 			File:    "",
-			Content: []byte("hello world"),
+			Content: NewPieceTable([]byte("hello world")),
 			CRLF:    false,
 		},
 		Cursor: Cursor{
 			Point: 2,
 			Mark:  2,
 		},
-		ActionStack: NewStack[BufferAction](10),
 	}
 
 	start, end := WordAtPoint(&bufferView)
@@ -230,3 +227,206 @@ func Test_WordAtPoint(t *testing.T) {
 //func Test_QueryReplace(t *testing.T)			{}
 //func Test_IsvalidCursorPosition(t *testing.T)   {}
 //func Test_AnotherSelectionOnMatch(t *testing.T) {}
+
+func Test_QueryReplace(t *testing.T) {
+	bufferView := BufferView{
+		Buffer: &Buffer{
+			File:    "",
+			Content: NewPieceTable([]byte("foo bar foo bar foo bar foo.")),
+		},
+		keymaps: NewUnboundedStack[Keymap](5),
+	}
+	re, _ := compileSearchPattern("foo", SearchOptions{})
+	bufferView.startQueryReplace("foo", "quux", re)
+
+	QueryReplaceReplaceThisMatch(&bufferView)
+	QueryReplaceIgnoreThisMatch(&bufferView)
+	QueryReplaceReplaceThisMatch(&bufferView)
+	assert.Equal(t, "quux bar foo bar quux bar foo.", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, []int{26, 28}, bufferView.QueryReplace.SearchMatches[3])
+
+	QueryReplaceBackup(&bufferView)
+	assert.Equal(t, "quux bar foo bar foo bar foo.", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, 2, bufferView.QueryReplace.CurrentMatch)
+	QueryReplaceBackup(&bufferView)
+	assert.Equal(t, 1, bufferView.QueryReplace.CurrentMatch)
+
+	QueryReplaceReplaceAll(&bufferView)
+	assert.Equal(t, "quux bar quux bar quux bar quux.", string(bufferView.Buffer.Content.Bytes()))
+	assert.False(t, bufferView.QueryReplace.IsQueryReplace)
+
+	RevertLastBufferAction(&bufferView)
+	assert.Equal(t, "foo bar foo bar foo bar foo.", string(bufferView.Buffer.Content.Bytes()))
+
+	bufferView.startQueryReplace("foo", "x", re)
+	QueryReplaceReplaceThisMatch(&bufferView)
+	QueryReplaceReplaceThisMatch(&bufferView)
+	QueryReplaceExit(&bufferView)
+	assert.Equal(t, "x bar x bar foo bar foo.", string(bufferView.Buffer.Content.Bytes()))
+	RevertLastBufferAction(&bufferView)
+	assert.Equal(t, "foo bar foo bar foo bar foo.", string(bufferView.Buffer.Content.Bytes()))
+}
+
+func Test_RegexQueryReplace(t *testing.T) {
+	bufferView := BufferView{
+		Buffer: &Buffer{
+			File:    "",
+			Content: NewPieceTable([]byte("func Foo(a int) {}\nfunc bar(b string) {}\nFoobar()")),
+		},
+		keymaps: NewUnboundedStack[Keymap](5),
+	}
+	re, err := compileSearchPattern(`func (?P<name>\w+)\((\w+)`, SearchOptions{})
+	assert.NoError(t, err)
+	bufferView.startQueryReplace("", "func ${name}Impl($2", re)
+	QueryReplaceReplaceAll(&bufferView)
+	assert.Equal(t, "func FooImpl(a int) {}\nfunc barImpl(b string) {}\nFoobar()", string(bufferView.Buffer.Content.Bytes()))
+
+	re, _ = compileSearchPattern("foo", SearchOptions{CaseSensitive: true, WholeWord: true})
+	assert.Nil(t, matchPattern(bufferView.Buffer.Content.Bytes(), re))
+	re, _ = compileSearchPattern("foo", SearchOptions{WholeWord: true})
+	assert.Nil(t, matchPattern(bufferView.Buffer.Content.Bytes(), re))
+	re, _ = compileSearchPattern("foo", SearchOptions{})
+	assert.Equal(t, [][]int{{5, 7}, {49, 51}}, matchPattern(bufferView.Buffer.Content.Bytes(), re))
+	re, _ = compileSearchPattern("(a int)", SearchOptions{Literal: true})
+	assert.Equal(t, [][]int{{12, 18}}, matchPattern(bufferView.Buffer.Content.Bytes(), re))
+
+	_, err = compileSearchPattern("func (", SearchOptions{})
+	assert.Error(t, err)
+}
+
+func Test_MultibyteEditing(t *testing.T) {
+	bufferView := newTestBufferView("")
+	for _, r := range "a世é🙂" {
+		BufferInsertChar(bufferView, r)
+	}
+	assert.Equal(t, "a世é🙂", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, 10, bufferView.Cursor.Point)
+
+	PointLeft(bufferView, 2)
+	assert.Equal(t, 4, bufferView.Cursor.Point)
+	PointRight(bufferView, 1)
+	assert.Equal(t, 6, bufferView.Cursor.Point)
+
+	DeleteCharBackward(bufferView)
+	assert.Equal(t, "a世🙂", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, 4, bufferView.Cursor.Point)
+	DeleteCharForward(bufferView)
+	assert.Equal(t, "a世", string(bufferView.Buffer.Content.Bytes()))
+
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, "a世é🙂", string(bufferView.Buffer.Content.Bytes()))
+
+	bufferView.Cursor = Cursor{Point: 0, Mark: 1}
+	bufferView.deleteSelectionsIfAnySelection()
+	assert.Equal(t, "é🙂", string(bufferView.Buffer.Content.Bytes()))
+}
+
+func Test_MultibytePointUpDown(t *testing.T) {
+	bufferView := newTestBufferView("世界x\nabcde\néé")
+	bufferView.Cursor.SetBoth(6) // after 世界, display column 4
+	PointDown(bufferView)
+	assert.Equal(t, 12, bufferView.Cursor.Point)
+	PointDown(bufferView)
+	assert.Equal(t, 18, bufferView.Cursor.Point)
+	PointUp(bufferView)
+	assert.Equal(t, 10, bufferView.Cursor.Point)
+	PointUp(bufferView)
+	assert.Equal(t, 3, bufferView.Cursor.Point)
+}
+
+func Test_Tabs(t *testing.T) {
+	content := "all:\n\tgo build\n    \t# mixed\n"
+	path := filepath.Join(t.TempDir(), "Makefile")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	bufferView := newTestBufferView("")
+	bufferView.Buffer.File = path
+	assert.NoError(t, bufferView.readFileFromDisk())
+	assert.Equal(t, content, string(bufferView.Buffer.Content.Bytes()))
+
+	bufferView.Cursor.SetBoth(3) // column 3 of "all:"
+	PointDown(bufferView)
+	assert.Equal(t, 5, bufferView.Cursor.Point) // still on the tab, it covers columns 0-3
+	PointRight(bufferView, 1)
+	assert.Equal(t, 4, bufferView.displayColumn(5, bufferView.Cursor.Point))
+	PointDown(bufferView)
+	assert.Equal(t, 19, bufferView.Cursor.Point) // tab after the four spaces starts at column 4
+
+	assert.NoError(t, bufferView.Save())
+	written, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(written))
+}
+
+func Test_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.sh")
+	assert.NoError(t, os.WriteFile(path, []byte("old"), 0755))
+
+	bufferView := newTestBufferView("new")
+	bufferView.cfg = &Config{BackupFiles: true}
+	bufferView.Buffer.File = path
+	bufferView.Buffer.fileType = FileType{BeforeSave: func(e *BufferView) error {
+		return errors.New("format failed")
+	}}
+	bufferView.SetStateDirty()
+
+	err := bufferView.Save()
+	assert.ErrorContains(t, err, "format failed")
+	assert.Equal(t, State_Clean, bufferView.Buffer.State)
+
+	written, _ := os.ReadFile(path)
+	assert.Equal(t, "new", string(written))
+	backup, _ := os.ReadFile(path + "~")
+	assert.Equal(t, "old", string(backup))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 2)
+
+	bufferView.Buffer.File = filepath.Join(path, "not-a-dir")
+	bufferView.SetStateDirty()
+	assert.Error(t, bufferView.Save())
+	assert.Equal(t, State_Dirty, bufferView.Buffer.State)
+}
+
+func TestSeekAround(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 5*seekWindow; i++ {
+		sb.WriteString(strings.Repeat("wörd", i%50) + " (")
+		if i%3 == 0 {
+			sb.WriteString(")\n")
+		}
+	}
+	sb.WriteString(strings.Repeat(")", 5000))
+	content := []byte(sb.String())
+	view := newTestBufferView(string(content))
+	view.AddBytesAtIndex([]byte("x"), 0, false)
+	view.RemoveRange(0, 1, false)
+	for idx := 0; idx <= len(content); idx += 97 {
+		for _, f := range []func([]byte, int) int{byteutils.SeekNextNonLetter, byteutils.SeekPreviousNonLetter, byteutils.FindMatching} {
+			assert.Equal(t, f(content, idx), view.seekAround(idx, 0, f), "at %d", idx)
+		}
+	}
+	assert.Nil(t, view.Buffer.Content.(*PieceTable).flat, "content is not flattened")
+}
+
+func TestCalcRenderStateWrapsVisibleRows(t *testing.T) {
+	bufferView := newTestBufferView(strings.Repeat("é", 100000) + "\nshort")
+	bufferView.cfg = &Config{}
+	bufferView.maxColumn = 11
+	bufferView.maxLine = 5
+	bufferView.calcRenderState()
+	assert.Len(t, bufferView.visibleLines, 5)
+	for i, line := range bufferView.visibleLines {
+		assert.Equal(t, 0, line.Index)
+		assert.Equal(t, i*20, line.startIndex)
+		assert.Equal(t, 20, line.Length)
+	}
+	assert.Equal(t, 22, bufferView.lineColumn(bufferView.bufferLine(0), 44))
+	assert.Equal(t, 30, bufferView.columnToIndex(bufferView.bufferLine(0), 15))
+
+	bufferView.maxLine = 100
+	bufferView.VisibleStart = 1
+	bufferView.calcRenderState()
+	assert.Equal(t, []BufferLine{{Index: 1, startIndex: 200001, endIndex: 200006, ActualLine: 2, Length: 5}}, bufferView.visibleLines)
+}
@@ -2,14 +2,41 @@ package byteutils
 
 import (
 	"unicode"
+	"unicode/utf8"
 )
 
+// NextRune returns index of the rune after the one starting at idx.
+func NextRune(bs []byte, idx int) int {
+	if idx < 0 {
+		return 0
+	}
+	if idx >= len(bs) {
+		return idx + 1
+	}
+	_, size := utf8.DecodeRune(bs[idx:])
+	return idx + size
+}
+
+// PreviousRune returns index of the rune before idx.
+func PreviousRune(bs []byte, idx int) int {
+	if idx > len(bs) {
+		return idx - 1
+	}
+	if idx <= 0 {
+		return idx - 1
+	}
+	_, size := utf8.DecodeLastRune(bs[:idx])
+	return idx - size
+}
+
+func isLetterAt(bs []byte, idx int) bool {
+	r, _ := utf8.DecodeRune(bs[idx:])
+	return unicode.IsLetter(r)
+}
+
 func SeekNextNonLetter(bs []byte, idx int) int {
-	for i := idx + 1; i < len(bs); i++ {
-		if i == len(bs) {
-			continue
-		}
-		if !unicode.IsLetter(rune(bs[i])) {
+	for i := NextRune(bs, idx); i < len(bs); i = NextRune(bs, i) {
+		if !isLetterAt(bs, i) {
 			return i
 		}
 	}
@@ -18,11 +45,11 @@ func SeekNextNonLetter(bs []byte, idx int) int {
 }
 
 func SeekPreviousNonLetter(bs []byte, idx int) int {
-	for i := idx - 1; i >= 0; i-- {
-		if i == len(bs) {
+	for i := PreviousRune(bs, idx); i >= 0; i = PreviousRune(bs, i) {
+		if i >= len(bs) {
 			continue
 		}
-		if !unicode.IsLetter(rune(bs[i])) {
+		if !isLetterAt(bs, i) {
 			return i
 		}
 	}
@@ -30,22 +57,19 @@ func SeekPreviousNonLetter(bs []byte, idx int) int {
 }
 
 func SeekPreviousLetter(bs []byte, idx int) int {
-	for i := idx - 1; i < len(bs); i++ {
-		if i == len(bs) {
+	for i := PreviousRune(bs, idx); i >= 0; i = PreviousRune(bs, i) {
+		if i >= len(bs) {
 			continue
 		}
-		if unicode.IsLetter(rune(bs[i])) {
+		if isLetterAt(bs, i) {
 			return i
 		}
 	}
 	return -1
 }
 func SeekNextLetter(bs []byte, idx int) int {
-	for i := idx + 1; i >= 0; i-- {
-		if i == len(bs) {
-			continue
-		}
-		if unicode.IsLetter(rune(bs[i])) {
+	for i := NextRune(bs, idx); i < len(bs); i = NextRune(bs, i) {
+		if isLetterAt(bs, i) {
 			return i
 		}
 	}
@@ -55,15 +79,18 @@ func SeekNextLetter(bs []byte, idx int) int {
 func PreviousWordInBuffer(bs []byte, idx int) int {
 	var sawWord bool
 	var sawWhitespaces bool
-	for i := idx - 1; i >= 0; i-- {
+	for i := PreviousRune(bs, idx); i >= 0; i = PreviousRune(bs, i) {
+		if i >= len(bs) {
+			continue
+		}
 		if sawWord {
-			return i + 1
+			return NextRune(bs, i)
 		}
 		if i == 0 {
 			return i
 		}
 
-		if !unicode.IsLetter(rune(bs[i])) {
+		if !isLetterAt(bs, i) {
 			sawWhitespaces = true
 		} else {
 			if sawWhitespaces {
@@ -81,11 +108,11 @@ func PreviousWordInBuffer(bs []byte, idx int) int {
 func NextWordInBuffer(bs []byte, idx int) int {
 	var sawWord bool
 	var sawWhitespaces bool
-	for i := idx + 1; i < len(bs); i++ {
+	for i := NextRune(bs, idx); i < len(bs); i = NextRune(bs, i) {
 		if sawWord {
-			return i + 1
+			return NextRune(bs, i)
 		}
-		if !unicode.IsLetter(rune(bs[i])) {
+		if !isLetterAt(bs, i) {
 			sawWhitespaces = true
 		} else {
 			if sawWhitespaces {
@@ -100,6 +127,57 @@ func NextWordInBuffer(bs []byte, idx int) int {
 	return -1
 }
 
+// RuneWidth returns number of monospace cells r takes on screen, east asian wide characters and emojis take two.
+func RuneWidth(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF && r != 0x303F,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x1F300 && r <= 0x1F64F,
+		r >= 0x1F900 && r <= 0x1F9FF,
+		r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
+
+// CellWidth returns number of cells r takes when drawn at column col, tabs extend to the next multiple of tabWidth.
+func CellWidth(r rune, col int, tabWidth int) int {
+	if r == '\t' && tabWidth > 0 {
+		return tabWidth - col%tabWidth
+	}
+	return RuneWidth(r)
+}
+
+// DisplayWidth returns number of cells bs takes on screen when it starts at column 0.
+func DisplayWidth(bs []byte, tabWidth int) int {
+	var width int
+	for len(bs) > 0 {
+		r, size := utf8.DecodeRune(bs)
+		width += CellWidth(r, width, tabWidth)
+		bs = bs[size:]
+	}
+	return width
+}
+
+// ColumnToIndex returns index of the rune that is drawn at column col, or len(bs) if bs is shorter than col.
+func ColumnToIndex(bs []byte, col int, tabWidth int) int {
+	var width int
+	for i := 0; i < len(bs); {
+		r, size := utf8.DecodeRune(bs[i:])
+		width += CellWidth(r, width, tabWidth)
+		if width > col {
+			return i
+		}
+		i += size
+	}
+	return len(bs)
+}
+
 func FindMatchingClosedForward(data []byte, idx int) int {
 	in := data[idx]
 	var matching byte
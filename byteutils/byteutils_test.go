@@ -17,3 +17,41 @@ func TestFindMatchingOpenBackward(t *testing.T) {
 	assert.Equal(t, 1, FindMatchingOpenBackward([]byte(`({[}])`), 3))
 	assert.Equal(t, 2, FindMatchingOpenBackward([]byte(`({[}])`), 4))
 }
+
+func TestRunes(t *testing.T) {
+	bs := []byte("aé世🙂")
+	assert.Equal(t, 1, NextRune(bs, 0))
+	assert.Equal(t, 3, NextRune(bs, 1))
+	assert.Equal(t, 6, NextRune(bs, 3))
+	assert.Equal(t, 10, NextRune(bs, 6))
+	assert.Equal(t, 6, PreviousRune(bs, 10))
+	assert.Equal(t, 3, PreviousRune(bs, 6))
+	assert.Equal(t, 1, PreviousRune(bs, 3))
+	assert.Equal(t, -1, PreviousRune(bs, 0))
+
+	assert.Equal(t, 6, DisplayWidth(bs, 4))
+	assert.Equal(t, 3, ColumnToIndex(bs, 2, 4))
+	assert.Equal(t, 3, ColumnToIndex(bs, 3, 4))
+	assert.Equal(t, 6, ColumnToIndex(bs, 4, 4))
+	assert.Equal(t, 10, ColumnToIndex(bs, 6, 4))
+}
+
+func TestTabWidth(t *testing.T) {
+	bs := []byte("\tab\tc")
+	assert.Equal(t, 9, DisplayWidth(bs, 4))
+	assert.Equal(t, 17, DisplayWidth(bs, 8))
+	assert.Equal(t, 0, ColumnToIndex(bs, 3, 4))
+	assert.Equal(t, 1, ColumnToIndex(bs, 4, 4))
+	assert.Equal(t, 3, ColumnToIndex(bs, 7, 4))
+	assert.Equal(t, 4, ColumnToIndex(bs, 8, 4))
+}
+
+func TestSeekNonLetterMultibyte(t *testing.T) {
+	bs := []byte("dé jà vü")
+	assert.Equal(t, 3, SeekNextNonLetter(bs, 0))
+	assert.Equal(t, 3, SeekPreviousNonLetter(bs, 7))
+	assert.Equal(t, 4, SeekNextLetter(bs, 3))
+	assert.Equal(t, 1, SeekPreviousLetter(bs, 3))
+	assert.Equal(t, 4, NextWordInBuffer(bs, 0))
+	assert.Equal(t, 5, PreviousWordInBuffer(bs, 9))
+}
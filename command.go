@@ -0,0 +1,119 @@
+package preditor
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// forceColorEnv asks programs to use colors even though their output is not a terminal.
+var forceColorEnv = []string{"TERM=xterm-256color", "CLICOLOR_FORCE=1", "FORCE_COLOR=1"}
+
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// SplitCommandLine splits s into words like a POSIX shell does, handling single quotes, double quotes and
+// backslash escapes. Variables, globs and operators are not expanded.
+func SplitCommandLine(s string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		escaped bool
+		quote   rune
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			// inside double quotes backslash only escapes a few characters.
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				word.WriteRune('\\')
+			}
+			if r != '\n' {
+				word.WriteRune(r)
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, ErrUnterminatedQuote
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+func isEnvAssignment(arg string) bool {
+	name, _, ok := strings.Cut(arg, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// CompileCommand returns the command that runs command line in dir, either through the configured shell or,
+// when CompileArgv is set, directly after splitting it into words where leading NAME=value words are added
+// to environment. CompileForceColor adds forceColorEnv to environment.
+func (c *Config) CompileCommand(command string, dir string) (*exec.Cmd, error) {
+	if !c.CompileArgv {
+		cmd := shellCommand(c.CompileShell, command)
+		cmd.Dir = dir
+		if c.CompileForceColor {
+			cmd.Env = append(cmd.Environ(), forceColorEnv...)
+		}
+		return cmd, nil
+	}
+	args, err := SplitCommandLine(command)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	if c.CompileForceColor {
+		env = append(env, forceColorEnv...)
+	}
+	for len(args) > 0 && isEnvAssignment(args[0]) {
+		env = append(env, args[0])
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Environ(), env...)
+	}
+	return cmd, nil
+}
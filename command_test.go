@@ -0,0 +1,69 @@
+package preditor
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{in: "go build ./...", want: []string{"go", "build", "./..."}},
+		{in: "  go   test  ", want: []string{"go", "test"}},
+		{in: `grep "hello world" 'it''s' a\ b`, want: []string{"grep", "hello world", "its", "a b"}},
+		{in: `echo "a \"b\" \n" ''`, want: []string{"echo", `a "b" \n`, ""}},
+		{in: `echo 'a\b'`, want: []string{"echo", `a\b`}},
+		{in: `echo "unterminated`, err: ErrUnterminatedQuote},
+		{in: `echo trailing\`, err: ErrUnterminatedQuote},
+		{in: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := SplitCommandLine(tt.in)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompileCommand(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig
+	cfg.CompileShell = "/bin/sh"
+
+	out, err := mustCompileCommand(t, &cfg, `FOO=bar; echo "$FOO baz" | tr a-z A-Z && pwd`, dir).Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "BAR BAZ\n"+dir+"\n", string(out))
+
+	cfg.CompileArgv = true
+	cmd := mustCompileCommand(t, &cfg, `FOO=bar sh -c 'echo "$FOO" "$1"' sh "two words"`, dir)
+	assert.Equal(t, []string{"sh", "-c", `echo "$FOO" "$1"`, "sh", "two words"}, cmd.Args)
+	out, err = cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "bar two words\n", string(out))
+
+	_, err = cfg.CompileCommand(`echo "oops`, dir)
+	assert.Error(t, err)
+
+	cfg.CompileForceColor = true
+	cmd = mustCompileCommand(t, &cfg, "FORCE_COLOR=0 env", dir)
+	out, err = cmd.Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "CLICOLOR_FORCE=1\n")
+	assert.Contains(t, string(out), "FORCE_COLOR=0\n")
+	assert.NotContains(t, string(out), "FORCE_COLOR=1\n")
+}
+
+func mustCompileCommand(t *testing.T, cfg *Config, command string, dir string) *exec.Cmd {
+	t.Helper()
+	cmd, err := cfg.CompileCommand(command, dir)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return cmd
+}
@@ -69,6 +69,13 @@ type Config struct {
 	CursorLineHighlight        bool
 	BuildWindowNormalHeight    float64
 	BuildWindowMaximizedHeight float64
+	BackupFiles                bool
+	CompileShell               string
+	CompileArgv                bool
+	CompileForceColor          bool
+	CompileOnSave              bool
+	ErrorFormats               []ErrorFormat
+	LargeFileThreshold         int64 // files at least this big are opened read only without loading them, 0 disables it
 }
 
 func (c *Config) String() string {
@@ -269,6 +276,7 @@ var defaultConfig = Config{
 	FontSize:                   17,
 	BuildWindowNormalHeight:    0.2,
 	BuildWindowMaximizedHeight: 0.5,
+	LargeFileThreshold:         64 << 20,
 }
 
 func (c *Config) CurrentThemeColors() *Colors {
@@ -307,6 +315,31 @@ func addToConfig(cfg *Config, key string, value string) error {
 		cfg.CursorLineHighlight = value == "true"
 	case "hl_matching_char":
 		cfg.HighlightMatchingParen = value == "true"
+	case "backup_files":
+		cfg.BackupFiles = value == "true"
+	case "compile_shell":
+		cfg.CompileShell = value
+	case "error_format":
+		name, pattern, _ := strings.Cut(value, " ")
+		return cfg.AddErrorFormat(name, strings.TrimSpace(pattern))
+	case "compile_on_save":
+		cfg.CompileOnSave = value == "true"
+	case "compile_force_color":
+		cfg.CompileForceColor = value == "true"
+	case "compile_argv":
+		cfg.CompileArgv = value == "true"
+	case "large_file_threshold":
+		mb, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		cfg.LargeFileThreshold = mb << 20
+	case "tab_size":
+		var err error
+		cfg.TabSize, err = strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
 	case "font_size":
 
 		var err error
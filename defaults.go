@@ -1,112 +1,22 @@
 package preditor
 
-import rl "github.com/gen2brain/raylib-go/raylib"
+import (
+	"unicode/utf8"
 
-func MakeInsertionKeys(insertor func(c *Context, b byte)) Keymap {
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// MakeInsertionKeys binds TextInputKey, insertor gets called with every printable character typed that
+// is not consumed by a key binding.
+func MakeInsertionKeys(insertor func(c *Context, r rune)) Keymap {
 	return Keymap{
-		Key{K: "a"}:                    func(c *Context) { insertor(c, 'a') },
-		Key{K: "b"}:                    func(c *Context) { insertor(c, 'b') },
-		Key{K: "c"}:                    func(c *Context) { insertor(c, 'c') },
-		Key{K: "d"}:                    func(c *Context) { insertor(c, 'd') },
-		Key{K: "e"}:                    func(c *Context) { insertor(c, 'e') },
-		Key{K: "f"}:                    func(c *Context) { insertor(c, 'f') },
-		Key{K: "g"}:                    func(c *Context) { insertor(c, 'g') },
-		Key{K: "h"}:                    func(c *Context) { insertor(c, 'h') },
-		Key{K: "i"}:                    func(c *Context) { insertor(c, 'i') },
-		Key{K: "j"}:                    func(c *Context) { insertor(c, 'j') },
-		Key{K: "k"}:                    func(c *Context) { insertor(c, 'k') },
-		Key{K: "l"}:                    func(c *Context) { insertor(c, 'l') },
-		Key{K: "m"}:                    func(c *Context) { insertor(c, 'm') },
-		Key{K: "n"}:                    func(c *Context) { insertor(c, 'n') },
-		Key{K: "o"}:                    func(c *Context) { insertor(c, 'o') },
-		Key{K: "p"}:                    func(c *Context) { insertor(c, 'p') },
-		Key{K: "q"}:                    func(c *Context) { insertor(c, 'q') },
-		Key{K: "r"}:                    func(c *Context) { insertor(c, 'r') },
-		Key{K: "s"}:                    func(c *Context) { insertor(c, 's') },
-		Key{K: "t"}:                    func(c *Context) { insertor(c, 't') },
-		Key{K: "u"}:                    func(c *Context) { insertor(c, 'u') },
-		Key{K: "v"}:                    func(c *Context) { insertor(c, 'v') },
-		Key{K: "w"}:                    func(c *Context) { insertor(c, 'w') },
-		Key{K: "x"}:                    func(c *Context) { insertor(c, 'x') },
-		Key{K: "y"}:                    func(c *Context) { insertor(c, 'y') },
-		Key{K: "z"}:                    func(c *Context) { insertor(c, 'z') },
-		Key{K: "0"}:                    func(c *Context) { insertor(c, '0') },
-		Key{K: "1"}:                    func(c *Context) { insertor(c, '1') },
-		Key{K: "2"}:                    func(c *Context) { insertor(c, '2') },
-		Key{K: "3"}:                    func(c *Context) { insertor(c, '3') },
-		Key{K: "4"}:                    func(c *Context) { insertor(c, '4') },
-		Key{K: "5"}:                    func(c *Context) { insertor(c, '5') },
-		Key{K: "6"}:                    func(c *Context) { insertor(c, '6') },
-		Key{K: "7"}:                    func(c *Context) { insertor(c, '7') },
-		Key{K: "8"}:                    func(c *Context) { insertor(c, '8') },
-		Key{K: "9"}:                    func(c *Context) { insertor(c, '9') },
-		Key{K: "\\"}:                   func(c *Context) { insertor(c, '\\') },
-		Key{K: "\\", Shift: true}:      func(c *Context) { insertor(c, '|') },
-		Key{K: "0", Shift: true}:       func(c *Context) { insertor(c, ')') },
-		Key{K: "1", Shift: true}:       func(c *Context) { insertor(c, '!') },
-		Key{K: "2", Shift: true}:       func(c *Context) { insertor(c, '@') },
-		Key{K: "3", Shift: true}:       func(c *Context) { insertor(c, '#') },
-		Key{K: "4", Shift: true}:       func(c *Context) { insertor(c, '$') },
-		Key{K: "5", Shift: true}:       func(c *Context) { insertor(c, '%') },
-		Key{K: "6", Shift: true}:       func(c *Context) { insertor(c, '^') },
-		Key{K: "7", Shift: true}:       func(c *Context) { insertor(c, '&') },
-		Key{K: "8", Shift: true}:       func(c *Context) { insertor(c, '*') },
-		Key{K: "9", Shift: true}:       func(c *Context) { insertor(c, '(') },
-		Key{K: "a", Shift: true}:       func(c *Context) { insertor(c, 'A') },
-		Key{K: "b", Shift: true}:       func(c *Context) { insertor(c, 'B') },
-		Key{K: "c", Shift: true}:       func(c *Context) { insertor(c, 'C') },
-		Key{K: "d", Shift: true}:       func(c *Context) { insertor(c, 'D') },
-		Key{K: "e", Shift: true}:       func(c *Context) { insertor(c, 'E') },
-		Key{K: "f", Shift: true}:       func(c *Context) { insertor(c, 'F') },
-		Key{K: "g", Shift: true}:       func(c *Context) { insertor(c, 'G') },
-		Key{K: "h", Shift: true}:       func(c *Context) { insertor(c, 'H') },
-		Key{K: "i", Shift: true}:       func(c *Context) { insertor(c, 'I') },
-		Key{K: "j", Shift: true}:       func(c *Context) { insertor(c, 'J') },
-		Key{K: "k", Shift: true}:       func(c *Context) { insertor(c, 'K') },
-		Key{K: "l", Shift: true}:       func(c *Context) { insertor(c, 'L') },
-		Key{K: "m", Shift: true}:       func(c *Context) { insertor(c, 'M') },
-		Key{K: "n", Shift: true}:       func(c *Context) { insertor(c, 'N') },
-		Key{K: "o", Shift: true}:       func(c *Context) { insertor(c, 'O') },
-		Key{K: "p", Shift: true}:       func(c *Context) { insertor(c, 'P') },
-		Key{K: "q", Shift: true}:       func(c *Context) { insertor(c, 'Q') },
-		Key{K: "r", Shift: true}:       func(c *Context) { insertor(c, 'R') },
-		Key{K: "s", Shift: true}:       func(c *Context) { insertor(c, 'S') },
-		Key{K: "t", Shift: true}:       func(c *Context) { insertor(c, 'T') },
-		Key{K: "u", Shift: true}:       func(c *Context) { insertor(c, 'U') },
-		Key{K: "v", Shift: true}:       func(c *Context) { insertor(c, 'V') },
-		Key{K: "w", Shift: true}:       func(c *Context) { insertor(c, 'W') },
-		Key{K: "x", Shift: true}:       func(c *Context) { insertor(c, 'X') },
-		Key{K: "y", Shift: true}:       func(c *Context) { insertor(c, 'Y') },
-		Key{K: "z", Shift: true}:       func(c *Context) { insertor(c, 'Z') },
-		Key{K: "["}:                    func(c *Context) { insertor(c, '[') },
-		Key{K: "]"}:                    func(c *Context) { insertor(c, ']') },
-		Key{K: "[", Shift: true}:       func(c *Context) { insertor(c, '{') },
-		Key{K: "]", Shift: true}:       func(c *Context) { insertor(c, '}') },
-		Key{K: ";"}:                    func(c *Context) { insertor(c, ';') },
-		Key{K: ";", Shift: true}:       func(c *Context) { insertor(c, ':') },
-		Key{K: "'"}:                    func(c *Context) { insertor(c, '\'') },
-		Key{K: "'", Shift: true}:       func(c *Context) { insertor(c, '"') },
-		Key{K: "\""}:                   func(c *Context) { insertor(c, '"') },
-		Key{K: ","}:                    func(c *Context) { insertor(c, ',') },
-		Key{K: "."}:                    func(c *Context) { insertor(c, '.') },
-		Key{K: ",", Shift: true}:       func(c *Context) { insertor(c, '<') },
-		Key{K: ".", Shift: true}:       func(c *Context) { insertor(c, '>') },
-		Key{K: "/"}:                    func(c *Context) { insertor(c, '/') },
-		Key{K: "/", Shift: true}:       func(c *Context) { insertor(c, '?') },
-		Key{K: "-"}:                    func(c *Context) { insertor(c, '-') },
-		Key{K: "="}:                    func(c *Context) { insertor(c, '=') },
-		Key{K: "-", Shift: true}:       func(c *Context) { insertor(c, '_') },
-		Key{K: "=", Shift: true}:       func(c *Context) { insertor(c, '+') },
-		Key{K: "`"}:                    func(c *Context) { insertor(c, '`') },
-		Key{K: "`", Shift: true}:       func(c *Context) { insertor(c, '~') },
-		Key{K: "<space>", Shift: true}: func(c *Context) { insertor(c, ' ') },
-		Key{K: "<space>"}:              func(c *Context) { insertor(c, ' ') },
+		TextInputKey: func(c *Context) { insertor(c, c.InputChar) },
 	}
 }
 
 func setupDefaults() {
-	PromptKeymap.SetKeys(MakeInsertionKeys(func(c *Context, b byte) {
-		c.Prompt.UserInput += string(b)
+	PromptKeymap.SetKeys(MakeInsertionKeys(func(c *Context, r rune) {
+		c.Prompt.UserInput += string(r)
 		if c.Prompt.ChangeHook != nil {
 			c.Prompt.ChangeHook(c.Prompt.UserInput, c)
 		}
@@ -123,7 +33,8 @@ func setupDefaults() {
 	})
 
 	PromptKeymap.BindKey(Key{K: "<backspace>"}, func(c *Context) {
-		c.Prompt.UserInput = c.Prompt.UserInput[:len(c.Prompt.UserInput)-1]
+		_, size := utf8.DecodeLastRuneInString(c.Prompt.UserInput)
+		c.Prompt.UserInput = c.Prompt.UserInput[:len(c.Prompt.UserInput)-size]
 		if c.Prompt.ChangeHook != nil {
 			c.Prompt.ChangeHook(c.Prompt.UserInput, c)
 		}
@@ -133,15 +44,19 @@ func setupDefaults() {
 		c.ResetPrompt()
 	})
 
-	BufferKeymap.SetKeys(MakeInsertionKeys(func(c *Context, b byte) {
-		BufferInsertChar(c.ActiveDrawable().(*BufferView), b)
+	BufferKeymap.SetKeys(MakeInsertionKeys(func(c *Context, r rune) {
+		BufferInsertChar(c.ActiveDrawable().(*BufferView), r)
 	}))
 
 	BufferKeymap.BindKey(Key{K: ",", Shift: true, Control: true}, MakeCommand(ScrollToTop))
 	BufferKeymap.BindKey(Key{K: "l", Control: true}, MakeCommand(CentralizePoint))
 	BufferKeymap.BindKey(Key{K: ";", Control: true}, MakeCommand(CompileNoAsk))
 	BufferKeymap.BindKey(Key{K: ";", Control: true, Shift: true}, MakeCommand(CompileAskForCommand))
+	BufferKeymap.BindKey(Key{K: ";", Control: true, Alt: true}, MakeCommand(ToggleCompileOnSave))
+	BufferKeymap.BindKey(Key{K: ";", Alt: true}, MakeCommand(ToggleBuildWatch))
 	BufferKeymap.BindKey(Key{K: "g", Alt: true}, MakeCommand(GrepAsk))
+	BufferKeymap.BindKey(Key{K: "o", Alt: true}, MakeCommand(Occur))
+	BufferKeymap.BindKey(Key{K: "o", Alt: true, Shift: true}, MakeCommand(OccurAllBuffers))
 	BufferKeymap.BindKey(Key{K: ".", Shift: true, Control: true}, MakeCommand(ScrollToBottom))
 	BufferKeymap.BindKey(Key{K: "<right>", Shift: true}, MakeCommand(func(e *BufferView) { MarkRight(e, 1) }))
 	BufferKeymap.BindKey(Key{K: "<right>", Shift: true, Control: true}, MakeCommand(MarkNextWord))
@@ -159,12 +74,16 @@ func setupDefaults() {
 	BufferKeymap.BindKey(Key{K: "m", Shift: true, Control: true}, MakeCommand(MarkToMatchingChar))
 	BufferKeymap.BindKey(Key{K: "r", Control: true}, MakeCommand(QueryReplaceActivate))
 	BufferKeymap.BindKey(Key{K: "r", Control: true, Shift: true}, MakeCommand(RevertBuffer))
-	BufferKeymap.BindKey(Key{K: "r", Alt: true}, MakeCommand(func(e *BufferView) {
-		e.readFileFromDisk()
-	}))
+	BufferKeymap.BindKey(Key{K: "r", Alt: true}, MakeCommand(RevertBuffer))
 	BufferKeymap.BindKey(Key{K: "z", Control: true}, MakeCommand(func(e *BufferView) {
 		RevertLastBufferAction(e)
 	}))
+	BufferKeymap.BindKey(Key{K: "z", Control: true, Shift: true}, MakeCommand(func(e *BufferView) {
+		RedoLastBufferAction(e)
+	}))
+	BufferKeymap.BindKey(Key{K: "z", Alt: true}, MakeCommand(func(e *BufferView) {
+		e.parent.OpenUndoTreeList(e)
+	}))
 	BufferKeymap.BindKey(Key{K: "f", Control: true}, MakeCommand(func(e *BufferView) {
 		PointRight(e, 1)
 	}))
@@ -277,10 +196,20 @@ func setupDefaults() {
 	BufferKeymap.BindKey(Key{K: "<tab>"}, MakeCommand(func(e *BufferView) { Indent(e) }))
 
 	CompileKeymap.BindKey(Key{K: "<enter>"}, BufferOpenLocationInCurrentLine)
+	CompileKeymap.BindKey(Key{K: "g"}, MakeCommand(RerunProcess))
+	CompileKeymap.BindKey(Key{K: "<lmouse>-click"}, MakeCommand(OpenLocationAtMouse))
+	CompileKeymap.BindKey(Key{K: "k"}, MakeCommand(InterruptProcess))
+	CompileKeymap.BindKey(Key{K: "k", Shift: true}, MakeCommand(KillProcess))
+	CompileKeymap.BindKey(Key{K: "e"}, MakeCommand(GrepEditMode))
+
+	GrepEditKeymap.BindKey(Key{K: "s", Control: true}, MakeCommand(GrepEditCommit))
+	GrepEditKeymap.BindKey(Key{K: "q", Control: true}, MakeCommand(GrepEditAbort))
 
 	GlobalKeymap.BindKey(Key{K: "\\", Alt: true}, func(c *Context) { VSplit(c) })
 	GlobalKeymap.BindKey(Key{K: "=", Alt: true}, func(c *Context) { HSplit(c) })
 	GlobalKeymap.BindKey(Key{K: ";", Control: true}, func(c *Context) { Compile(c) })
+	GlobalKeymap.BindKey(Key{K: "n", Alt: true}, NextLocation)
+	GlobalKeymap.BindKey(Key{K: "p", Alt: true}, PreviousLocation)
 	GlobalKeymap.BindKey(Key{K: "q", Alt: true}, func(c *Context) { c.CloseWindow(c.ActiveWindowIndex) })
 	GlobalKeymap.BindKey(Key{K: "q", Alt: true, Shift: true}, Exit)
 	GlobalKeymap.BindKey(Key{K: "0", Control: true}, func(c *Context) { c.CloseWindow(c.ActiveWindowIndex) })
@@ -289,6 +218,7 @@ func setupDefaults() {
 	GlobalKeymap.BindKey(Key{K: "t", Alt: true}, func(c *Context) { c.OpenThemesList() })
 	GlobalKeymap.BindKey(Key{K: "o", Control: true}, func(c *Context) { c.OpenFileList() })
 	GlobalKeymap.BindKey(Key{K: "b", Alt: true}, func(c *Context) { c.OpenBufferList() })
+	GlobalKeymap.BindKey(Key{K: "e", Alt: true}, func(c *Context) { c.OpenDiagnosticsList() })
 	GlobalKeymap.BindKey(Key{K: "<mouse-wheel-down>", Control: true}, func(c *Context) { c.DecreaseFontSize(2) })
 	GlobalKeymap.BindKey(Key{K: "<mouse-wheel-up>", Control: true}, func(c *Context) { c.IncreaseFontSize(2) })
 	GlobalKeymap.BindKey(Key{K: "=", Control: true}, func(c *Context) { c.IncreaseFontSize(2) })
@@ -352,6 +282,31 @@ func setupDefaults() {
 	QueryReplaceKeymap.BindKey(Key{K: "<esc>"}, MakeCommand(func(editor *BufferView) {
 		QueryReplaceExit(editor)
 	}))
+	QueryReplaceKeymap.BindKey(Key{K: "q"}, MakeCommand(func(editor *BufferView) {
+		QueryReplaceExit(editor)
+	}))
+	QueryReplaceKeymap.BindKey(Key{K: "n"}, MakeCommand(func(editor *BufferView) {
+		QueryReplaceIgnoreThisMatch(editor)
+	}))
+	QueryReplaceKeymap.BindKey(Key{K: "."}, MakeCommand(func(editor *BufferView) {
+		QueryReplaceReplaceAndStop(editor)
+	}))
+	// ! and ^ are on different keys in every layout so they are matched on typed character, anything else
+	// typed is ignored instead of being inserted in the buffer.
+	QueryReplaceKeymap.BindKey(TextInputKey, MakeCommand(func(editor *BufferView) {
+		switch editor.parent.InputChar {
+		case '!':
+			QueryReplaceReplaceAll(editor)
+		case '^':
+			QueryReplaceBackup(editor)
+		}
+	}))
+	QueryReplaceKeymap.BindKey(Key{K: "<backspace>"}, MakeCommand(func(editor *BufferView) {
+		QueryReplaceBackup(editor)
+	}))
+	QueryReplaceKeymap.BindKey(Key{K: "z", Control: true}, MakeCommand(func(editor *BufferView) {
+		QueryReplaceBackup(editor)
+	}))
 	QueryReplaceKeymap.BindKey(Key{K: "<lmouse>-click"}, MakeCommand(func(e *BufferView) {
 		e.moveCursorTo(rl.GetMousePosition())
 	}))
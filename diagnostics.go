@@ -0,0 +1,203 @@
+package preditor
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Diagnostic is an error or warning found in output of the last build. Line and Column are 1-based as
+// reported, once attached to the Buffer of its file Start and End are byte offsets of the reported range and
+// move as the buffer is edited.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+
+	Buffer *Buffer
+	Start  int
+	End    int
+}
+
+// Position returns current 1-based line and column of d, taking edits since the build into account.
+func (d *Diagnostic) Position() (int, int) {
+	if d.Buffer == nil {
+		return d.Line, d.Column
+	}
+	pos := d.Buffer.Lines().OffsetToPosition(d.Start)
+	return pos.Line + 1, pos.Column + 1
+}
+
+func (d *Diagnostic) String() string {
+	line, col := d.Position()
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, line, col, d.Severity, d.Message)
+}
+
+// ParseDiagnostics returns a diagnostic for every location in compilation output. Lines without a message
+// (like rustc's `-->`) take the previous line as message.
+func ParseDiagnostics(formats []ErrorFormat, output []byte, dir string) []*Diagnostic {
+	lines := bytes.Split(output, []byte("\n"))
+	var diags []*Diagnostic
+	for i, line := range lines {
+		loc, ok := ParseLocation(formats, line, dir)
+		if !ok {
+			continue
+		}
+		msg := strings.TrimSpace(strings.TrimLeft(string(line[loc.End:]), ":"))
+		if msg == "" && i > 0 {
+			msg = strings.TrimSpace(string(lines[i-1]))
+		}
+		severity := loc.Severity
+		if severity == "" {
+			severity = "error"
+			if strings.HasPrefix(msg, "warning") {
+				severity = "warning"
+			}
+		}
+		diags = append(diags, &Diagnostic{File: loc.File, Line: loc.Line, Column: loc.Column, Severity: severity, Message: msg})
+	}
+	return diags
+}
+
+// diagnosticRange returns byte range of the word at line and col, or of the whole line when col is 0.
+func diagnosticRange(buf *Buffer, line int, col int) (int, int) {
+	lines := buf.Lines()
+	line = min(max(line-1, 0), lines.LineCount()-1)
+	start, end := lines.LineStart(line), lines.LineEnd(line)
+	content := buf.Content.Slice(start, end)
+	if col < 1 {
+		trimmed := bytes.TrimLeftFunc(content, unicode.IsSpace)
+		return end - len(trimmed), start + len(bytes.TrimRightFunc(content, unicode.IsSpace))
+	}
+	from := min(col-1, len(content))
+	to := from
+	for to < len(content) {
+		r, size := utf8.DecodeRune(content[to:])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			if to == from {
+				to += size
+			}
+			break
+		}
+		to += size
+	}
+	return start + from, start + to
+}
+
+// shiftDiagnostics moves diagnostics after n bytes are inserted (n > 0) or deleted (n < 0) at idx.
+func (b *Buffer) shiftDiagnostics(idx int, n int) {
+	move := func(x int) int {
+		switch {
+		case n >= 0 && x >= idx:
+			return x + n
+		case n < 0 && x >= idx-n:
+			return x + n
+		case n < 0 && x > idx:
+			return idx
+		}
+		return x
+	}
+	for _, d := range b.Diagnostics {
+		if n > 0 && d.Start < idx && d.End > idx {
+			// insertion inside the range grows it.
+			d.End += n
+			continue
+		}
+		d.Start, d.End = move(d.Start), move(d.End)
+	}
+}
+
+func (b *Buffer) resetDiagnostics() {
+	for _, d := range b.Diagnostics {
+		d.Start, d.End = diagnosticRange(b, d.Line, d.Column)
+	}
+}
+
+func sameFile(a string, b string) bool {
+	if abs, err := filepath.Abs(a); err == nil {
+		a = abs
+	}
+	if abs, err := filepath.Abs(b); err == nil {
+		b = abs
+	}
+	return a == b
+}
+
+func (c *Context) attachDiagnostics(buf *Buffer) {
+	buf.Diagnostics = nil
+	for _, d := range c.Diagnostics {
+		if sameFile(d.File, buf.File) {
+			d.Buffer = buf
+			d.Start, d.End = diagnosticRange(buf, d.Line, d.Column)
+			buf.Diagnostics = append(buf.Diagnostics, d)
+		}
+	}
+}
+
+// SetDiagnostics replaces diagnostics of the last build and attaches them to open buffers.
+func (c *Context) SetDiagnostics(diags []*Diagnostic) {
+	c.Diagnostics = diags
+	for _, buf := range c.Buffers {
+		c.attachDiagnostics(buf)
+	}
+}
+
+func diagnosticColor(severity string) color.RGBA {
+	switch severity {
+	case "error":
+		return rl.Red
+	case "warning":
+		return rl.Orange
+	}
+	return rl.SkyBlue
+}
+
+// renderDiagnostics draws gutter markers and underlines for diagnostics in view and message of the first
+// diagnostic on cursor line after its end.
+func (e *BufferView) renderDiagnostics(zeroLocation rl.Vector2) {
+	if len(e.Buffer.Diagnostics) == 0 || len(e.visibleLines) == 0 {
+		return
+	}
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	cursorLine := e.Buffer.Lines().LineForOffset(e.Cursor.Point)
+	var cursorDiagnostic *Diagnostic
+	for _, d := range e.Buffer.Diagnostics {
+		color := diagnosticColor(d.Severity)
+		line := e.Buffer.Lines().LineForOffset(d.Start)
+		if row, _, visible := e.screenPosition(e.Buffer.Lines().LineStart(line)); visible {
+			posX, posY := e.cellPosition(zeroLocation, row, 0)
+			rl.DrawRectangle(max(posX-4, int32(zeroLocation.X)), posY, 3, int32(charSize.Y), color)
+		}
+		e.visibleSegments(d.Start, d.End, func(row int, segment BufferLine, from int, to int) {
+			col := e.displayColumn(segment.startIndex, from)
+			posX, posY := e.cellPosition(zeroLocation, row, col)
+			width := e.displayColumn(segment.startIndex, to) - col
+			rl.DrawRectangle(posX, posY+int32(charSize.Y)-2, int32(width)*int32(charSize.X), 2, color)
+		})
+		if line == cursorLine && cursorDiagnostic == nil {
+			cursorDiagnostic = d
+		}
+	}
+	if cursorDiagnostic == nil {
+		return
+	}
+	row, col, visible := e.screenPosition(e.Buffer.Lines().LineEnd(cursorLine))
+	room := int(e.maxColumn) - col - 2
+	if !visible || room <= 0 {
+		return
+	}
+	msg := []rune(cursorDiagnostic.Message)
+	if len(msg) > room {
+		msg = msg[:room]
+	}
+	posX, posY := e.cellPosition(zeroLocation, row, col+2)
+	rl.DrawTextEx(e.parent.Font, string(msg), rl.Vector2{X: float32(posX), Y: float32(posY)}, float32(e.parent.FontSize), 0, diagnosticColor(cursorDiagnostic.Severity))
+}
@@ -0,0 +1,79 @@
+package preditor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := []byte("Command: go build\n" +
+		"main.go:3:2: undefined: foo\n" +
+		"lib.c:1:5: warning: unused variable\n" +
+		"warning: unused import\n" +
+		"  --> src/lib.rs:2:1\n" +
+		"Finished in 1s\n")
+	diags := ParseDiagnostics(defaultConfig.AllErrorFormats(), output, "/src")
+	assert.Equal(t, []*Diagnostic{
+		{File: "/src/main.go", Line: 3, Column: 2, Severity: "error", Message: "undefined: foo"},
+		{File: "/src/lib.c", Line: 1, Column: 5, Severity: "warning", Message: "warning: unused variable"},
+		{File: "/src/src/lib.rs", Line: 2, Column: 1, Severity: "warning", Message: "warning: unused import"},
+	}, diags)
+}
+
+func TestDiagnosticsFollowEdits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.go")
+	assert.NoError(t, os.WriteFile(path, []byte("package main\n\tfoo(bar)\n\n"), 0644))
+	c := newTestContext()
+	c.SetDiagnostics([]*Diagnostic{
+		{File: path, Line: 2, Column: 6, Severity: "error", Message: "undefined: bar"},
+		{File: path, Line: 3, Severity: "error", Message: "empty line"},
+	})
+	buf := c.OpenFileAsBuffer(path)
+	assert.Len(t, buf.Diagnostics, 2)
+	d := buf.Diagnostics[0]
+	assert.Equal(t, "bar", string(buf.Content.Slice(d.Start, d.End)))
+
+	buf.Insert(0, []byte("// hi\n"))
+	assert.Equal(t, "bar", string(buf.Content.Slice(d.Start, d.End)))
+	line, col := d.Position()
+	assert.Equal(t, []int{3, 6}, []int{line, col})
+
+	buf.Insert(d.Start+1, []byte("aaa"))
+	assert.Equal(t, "baaaar", string(buf.Content.Slice(d.Start, d.End)))
+
+	buf.Delete(d.Start-2, d.Start+2)
+	assert.Equal(t, "aaar", string(buf.Content.Slice(d.Start, d.End)))
+
+	buf.Delete(d.Start, d.End)
+	assert.Equal(t, d.Start, d.End)
+
+	buf.Reset([]byte("package main\n\tfoo(bar)\n"))
+	assert.Equal(t, "bar", string(buf.Content.Slice(d.Start, d.End)))
+
+	c.SetDiagnostics(nil)
+	assert.Empty(t, buf.Diagnostics)
+}
+
+func TestCompilationSetsDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestContext()
+	c.CWD = dir
+	path := filepath.Join(dir, "a.go")
+	assert.NoError(t, os.WriteFile(path, []byte("package a\nvar x = y\n"), 0644))
+	buf := c.OpenFileAsBuffer(path)
+
+	view, err := NewCompilationBuffer(c, c.Cfg, `echo "a.go:2:9: undefined: y"; exit 1`)
+	assert.NoError(t, err)
+	runUntil(t, c, func() bool { return len(buf.Diagnostics) == 1 })
+	assert.Contains(t, string(view.Buffer.Content.Bytes()), "Exited with code 1")
+	assert.Equal(t, "y", string(buf.Content.Slice(buf.Diagnostics[0].Start, buf.Diagnostics[0].End)))
+
+	view, err = NewCompilationBuffer(c, c.Cfg, "true")
+	assert.NoError(t, err)
+	runUntil(t, c, func() bool { return len(c.Diagnostics) == 0 })
+	assert.Empty(t, buf.Diagnostics)
+}
@@ -0,0 +1,169 @@
+package preditor
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	DiffEqual  = ' '
+	DiffDelete = '-'
+	DiffInsert = '+'
+)
+
+type DiffOp struct {
+	Kind byte
+	Line []byte
+}
+
+func splitLines(bs []byte) [][]byte {
+	if len(bs) == 0 {
+		return nil
+	}
+	lines := bytes.SplitAfter(bs, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// DiffLines returns a shortest edit script turning a into b, using Myers algorithm.
+func DiffLines(a, b [][]byte) []DiffOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace[d] holds v for diagonals -d..d before round d.
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(trace, a, b)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrackDiff(trace [][]int, a, b [][]byte) []DiffOp {
+	x, y := len(a), len(b)
+	var ops []DiffOp
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, DiffOp{Kind: DiffEqual, Line: a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, DiffOp{Kind: DiffInsert, Line: b[y-1]})
+			y--
+		} else {
+			ops = append(ops, DiffOp{Kind: DiffDelete, Line: a[x-1]})
+			x--
+		}
+	}
+	for ; x > 0; x-- {
+		ops = append(ops, DiffOp{Kind: DiffEqual, Line: a[x-1]})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// UnifiedDiff returns differences between a and b in unified diff format with 3 lines of context.
+func UnifiedDiff(aName string, bName string, a []byte, b []byte) []byte {
+	const context = 3
+	ops := DiffLines(splitLines(a), splitLines(b))
+	// line numbers in a and b where each op starts.
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if op.Kind != DiffInsert {
+			aLine[i+1]++
+		}
+		if op.Kind != DiffDelete {
+			bLine[i+1]++
+		}
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].Kind == DiffEqual {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+		}
+		start := max(0, i-context)
+		end := i + 1
+		for j := i; j < len(ops); j++ {
+			if ops[j].Kind != DiffEqual {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(len(ops), end+context)
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(aLine[start], aLine[end]-aLine[start]), hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.Kind)
+			out.Write(op.Line)
+			if !bytes.HasSuffix(op.Line, []byte("\n")) {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// OpenDiffBuffer shows differences between two versions of file in current window, CRLFs are ignored.
+func (c *Context) OpenDiffBuffer(file string, aName string, bName string, a []byte, b []byte) {
+	a = bytes.ReplaceAll(a, []byte("\r\n"), []byte("\n"))
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	diff := UnifiedDiff(fmt.Sprintf("%s (%s)", file, aName), fmt.Sprintf("%s (%s)", file, bName), a, b)
+	diffView := NewBufferViewFromFilename(c, c.Cfg, "*Diff*@"+file)
+	diffView.Buffer.Readonly = true
+	diffView.Buffer.Reset(diff)
+	c.AddDrawable(diffView)
+	c.MarkDrawableAsActive(diffView.ID)
+}
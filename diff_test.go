@@ -0,0 +1,39 @@
+package preditor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n")
+	b := []byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n")
+	assert.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`, string(UnifiedDiff("old", "new", a, b)))
+
+	assert.Empty(t, UnifiedDiff("old", "new", a, a))
+	assert.Equal(t, "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n\\ No newline at end of file\n", string(UnifiedDiff("old", "new", nil, []byte("x"))))
+}
+
+func TestDiffLines(t *testing.T) {
+	ops := DiffLines(splitLines([]byte("x\ny\nz\n")), splitLines([]byte("y\nz\nw\n")))
+	var kinds []byte
+	for _, op := range ops {
+		kinds = append(kinds, op.Kind)
+	}
+	assert.Equal(t, "-  +", string(kinds))
+}
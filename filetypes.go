@@ -19,12 +19,12 @@ var GoFileType = FileType{
 	TabSize:    4,
 	TSLanguage: golang.GetLanguage(),
 	BeforeSave: func(e *BufferView) error {
-		newBytes, err := format.Source(e.Buffer.Content)
+		newBytes, err := format.Source(e.Buffer.Content.Bytes())
 		if err != nil {
 			return err
 		}
 
-		e.Buffer.Content = newBytes
+		e.ReplaceContent(newBytes)
 		return nil
 	},
 	TSHighlightQuery: []byte(`
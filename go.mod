@@ -8,13 +8,13 @@ require (
 	github.com/gen2brain/raylib-go/raylib v0.0.0-20231118131254-fed470e4458f
 	github.com/lithammer/fuzzysearch v1.1.8
 	github.com/smacker/go-tree-sitter v0.0.0-20231215063300-06670b6cd560
+	github.com/stretchr/testify v1.8.4
 	golang.design/x/clipboard v0.7.0
 )
 
 require (
 	github.com/ebitengine/purego v0.5.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
 	golang.org/x/exp v0.0.0-20190731235908-ec7cb31e5a56 // indirect
 	golang.org/x/image v0.6.0 // indirect
 	golang.org/x/mobile v0.0.0-20230301163155-e0f57694e12c // indirect
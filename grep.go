@@ -0,0 +1,414 @@
+package preditor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// HasRipgrep reports whether rg is in PATH, searching and listing files use it when it's available and
+// fall back to NativeGrep and NativeListFiles when it's not.
+var HasRipgrep = sync.OnceValue(func() bool {
+	_, err := exec.LookPath("rg")
+	return err == nil
+})
+
+// ListFiles returns files under dir relative to it, skipping ignored and hidden files.
+func ListFiles(dir string) []string {
+	if HasRipgrep() {
+		return RipgrepFiles(dir)
+	}
+	files, err := NativeListFiles(context.Background(), dir)
+	if err != nil {
+		fmt.Println("ERROR listing files:", err.Error())
+	}
+	return files
+}
+
+// GrepScope is the directory grep searches in.
+type GrepScope int
+
+const (
+	GrepScopeFileDir GrepScope = iota // directory of current file or of current compilation/grep output
+	GrepScopeProject                  // project root of current file
+	GrepScopeCWD                      // directory editor was started in
+	grepScopeCount
+)
+
+func (s GrepScope) String() string {
+	switch s {
+	case GrepScopeProject:
+		return "project"
+	case GrepScopeCWD:
+		return "cwd"
+	default:
+		return "file dir"
+	}
+}
+
+// GrepQuery is a search run by grep, Globs is a space separated list of `rg -g` globs.
+type GrepQuery struct {
+	Pattern string
+	Options SearchOptions
+	Globs   string
+	Scope   GrepScope
+}
+
+func (q GrepQuery) GlobList() []string {
+	return strings.Fields(q.Globs)
+}
+
+// RipgrepArgs returns arguments that make rg search for q.
+func (q GrepQuery) RipgrepArgs() []string {
+	args := []string{"--vimgrep"}
+	if q.Options.CaseSensitive {
+		args = append(args, "--case-sensitive")
+	} else {
+		args = append(args, "--ignore-case")
+	}
+	if q.Options.Literal {
+		args = append(args, "--fixed-strings")
+	}
+	if q.Options.WholeWord {
+		args = append(args, "--word-regexp")
+	}
+	for _, glob := range q.GlobList() {
+		args = append(args, "--glob", glob)
+	}
+	return append(args, "--regexp", q.Pattern)
+}
+
+func (q GrepQuery) String() string {
+	s := fmt.Sprintf("%s [%s]", q.Pattern, q.Options)
+	if q.Globs != "" {
+		s += " -g " + q.Globs
+	}
+	return s
+}
+
+// GrepDir returns directory grep searches in for scope.
+func (c *Context) GrepDir(scope GrepScope) string {
+	switch scope {
+	case GrepScopeProject:
+		if view, ok := c.ActiveDrawable().(*BufferView); ok && !view.IsSpecial() {
+			return view.ProjectRoot()
+		}
+		// FindProjectRoot starts from directory of the path it's given.
+		return FindProjectRoot(filepath.Join(c.getCWD(), "_"))
+	case GrepScopeCWD:
+		if c.CWD != "" {
+			return c.CWD
+		}
+	}
+	return c.getCWD()
+}
+
+// GrepMatchCount returns number of matches and files in grep output.
+func GrepMatchCount(output []byte, dir string) (int, int) {
+	matches := 0
+	files := map[string]bool{}
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if key, _, ok := parseGrepLine(line, dir); ok {
+			matches++
+			files[key.File] = true
+		}
+	}
+	return matches, len(files)
+}
+
+type ignoreRule struct {
+	base    string // directory of the .gitignore relative to walk root, "" for root
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// globToRegexp converts a gitignore glob to a regexp source, `**` matches across directories.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		ch := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case ch == '*':
+			sb.WriteString("[^/]*")
+		case ch == '?':
+			sb.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return sb.String()
+}
+
+// parseGitignore returns rules of a .gitignore in base directory, invalid patterns are skipped.
+func parseGitignore(base string, content []byte) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		prefix := "(?:.*/)?"
+		if strings.Contains(line, "/") {
+			// patterns with a slash are relative to the .gitignore directory.
+			prefix = ""
+			line = strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile("^" + prefix + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// isIgnored checks path relative to walk root against rules, last matching rule wins.
+func isIgnored(rules []ignoreRule, rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		p := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			p = rel[len(rule.base)+1:]
+		}
+		if rule.re.MatchString(p) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// walkFiles calls f with every file under root that is not hidden, ignored by a .gitignore or rejected by
+// filter (when it's not nil), paths are relative to root and use forward slashes.
+func walkFiles(ctx context.Context, root string, filter func(rel string, isDir bool) bool, f func(rel string) error) error {
+	rules := map[string][]ignoreRule{}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		parent := ""
+		if i := strings.LastIndexByte(rel, '/'); i != -1 {
+			parent = rel[:i]
+		}
+		if path != root {
+			if strings.HasPrefix(d.Name(), ".") || isIgnored(rules[parent], rel, d.IsDir()) || (filter != nil && !filter(rel, d.IsDir())) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.IsDir() {
+			if rel == "." {
+				rel = ""
+			}
+			dirRules := rules[parent]
+			if path == root {
+				dirRules = nil
+			}
+			if content, err := os.ReadFile(filepath.Join(path, ".gitignore")); err == nil {
+				dirRules = append(dirRules[:len(dirRules):len(dirRules)], parseGitignore(rel, content)...)
+			}
+			rules[rel] = dirRules
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return f(rel)
+	})
+}
+
+// NativeListFiles returns files under dir the same way `rg --files` does.
+func NativeListFiles(ctx context.Context, dir string) ([]string, error) {
+	var files []string
+	err := walkFiles(ctx, dir, nil, func(rel string) error {
+		files = append(files, filepath.FromSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+// globFilter returns a filter for walkFiles that works like `rg -g`: globs use gitignore syntax, `!glob`
+// excludes and when there are include globs a file has to match one of them. Last matching glob wins.
+func globFilter(globs []string) func(rel string, isDir bool) bool {
+	var lines []string
+	hasInclude := false
+	for _, glob := range globs {
+		// as gitignore rules includes are negations and excludes are ignores.
+		if strings.HasPrefix(glob, "!") {
+			lines = append(lines, glob[1:])
+		} else {
+			lines = append(lines, "!"+glob)
+			hasInclude = true
+		}
+	}
+	rules := parseGitignore("", []byte(strings.Join(lines, "\n")))
+	return func(rel string, isDir bool) bool {
+		allowed := isDir || !hasInclude
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				allowed = rule.negate
+			}
+		}
+		return allowed
+	}
+}
+
+// isBinary reports whether content looks binary, like git and rg we look for a NUL in the beginning.
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) != -1
+}
+
+// grepFile writes a `file:line:col:text` line for every match of re in content.
+func grepFile(out *bytes.Buffer, rel string, content []byte, re *regexp.Regexp) {
+	for lineNum := 1; len(content) > 0; lineNum++ {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i != -1 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		matches := re.FindAllIndex(line, -1)
+		reported := false
+		for _, m := range matches {
+			if m[0] == m[1] {
+				continue
+			}
+			fmt.Fprintf(out, "%s:%d:%d:%s\n", rel, lineNum, m[0]+1, line)
+			reported = true
+		}
+		if !reported && len(matches) > 0 {
+			// only empty matches, report the line once.
+			fmt.Fprintf(out, "%s:%d:%d:%s\n", rel, lineNum, matches[0][0]+1, line)
+		}
+	}
+}
+
+// NativeGrep searches files under dir for query concurrently and writes matches to w in `rg --vimgrep`
+// format, binary files are skipped. Output of every file is written at once.
+func NativeGrep(ctx context.Context, dir string, query GrepQuery, w io.Writer) error {
+	re, err := compileSearchPattern(query.Pattern, query.Options)
+	if err != nil {
+		return err
+	}
+	var filter func(rel string, isDir bool) bool
+	if globs := query.GlobList(); len(globs) > 0 {
+		filter = globFilter(globs)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make(chan string)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		writeErr error
+	)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var out bytes.Buffer
+			for rel := range files {
+				content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+				if err != nil || isBinary(content) {
+					continue
+				}
+				out.Reset()
+				grepFile(&out, filepath.FromSlash(rel), content, re)
+				if out.Len() == 0 {
+					continue
+				}
+				mu.Lock()
+				if writeErr == nil {
+					if _, err := w.Write(out.Bytes()); err != nil {
+						writeErr = err
+						cancel()
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	walkErr := walkFiles(ctx, dir, filter, func(rel string) error {
+		select {
+		case files <- rel:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+	if writeErr != nil {
+		return writeErr
+	}
+	return walkErr
+}
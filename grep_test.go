@@ -0,0 +1,197 @@
+package preditor
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTree(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return root
+}
+
+func TestNativeListFiles(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		".gitignore":         "*.log\n!keep.log\nbuild/\n/root.txt\ndocs/**/*.tmp\n# comment\n",
+		"main.go":            "",
+		"a.log":              "",
+		"keep.log":           "",
+		"root.txt":           "",
+		"sub/root.txt":       "",
+		"sub/b.log":          "",
+		"sub/.gitignore":     "local.txt\n",
+		"sub/local.txt":      "",
+		"local.txt":          "",
+		"build/out":          "",
+		"sub/build/out":      "",
+		"docs/x/y/z.tmp":     "",
+		"docs/readme.md":     "",
+		".hidden/file":       "",
+		".env":               "",
+		"vendor/lib/[x].go":  "",
+		"vendor/.gitignore":  "!*.log\n",
+		"vendor/kept.log":    "",
+		"vendor/lib/ok.txt":  "",
+		"vendor/lib/no.swp":  "",
+		"vendor/lib/.ignore": "",
+	})
+	files, err := NativeListFiles(context.Background(), root)
+	assert.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.ToSlash(files[i])
+	}
+	sort.Strings(files)
+	assert.Equal(t, []string{
+		"docs/readme.md",
+		"keep.log",
+		"local.txt",
+		"main.go",
+		"sub/root.txt",
+		"vendor/kept.log",
+		"vendor/lib/[x].go",
+		"vendor/lib/no.swp",
+		"vendor/lib/ok.txt",
+	}, files)
+}
+
+func TestNativeGrep(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.go":       "package a\nfunc foo() { foo() }\r\n",
+		"sub/b.txt":  "no match\nfoo",
+		"binary.bin": "foo\x00bar",
+		"ignored.go": "foo",
+		".gitignore": "ignored.go",
+	})
+	var out bytes.Buffer
+	assert.NoError(t, NativeGrep(context.Background(), root, GrepQuery{Pattern: "fo+", Options: SearchOptions{CaseSensitive: true}}, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		"a.go:2:14:func foo() { foo() }",
+		"a.go:2:6:func foo() { foo() }",
+		filepath.FromSlash("sub/b.txt") + ":2:1:foo",
+	}, lines)
+
+	assert.Error(t, NativeGrep(context.Background(), root, GrepQuery{Pattern: "("}, &out))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, NativeGrep(ctx, root, GrepQuery{Pattern: "foo"}, &out), context.Canceled)
+}
+
+func TestNativeGrepOptions(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"a.go":            "Foo foobar\n",
+		"a.txt":           "foo\n",
+		"vendor/v.go":     "foo\n",
+		"sub/vendor/w.go": "foo\n",
+		"sub/b.go":        "f.o\n",
+	})
+	grep := func(q GrepQuery) []string {
+		var out bytes.Buffer
+		assert.NoError(t, NativeGrep(context.Background(), root, q, &out))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		sort.Strings(lines)
+		return lines
+	}
+	assert.Equal(t, []string{"a.go:1:1:Foo foobar", "a.go:1:5:Foo foobar"},
+		grep(GrepQuery{Pattern: "foo", Globs: "*.go !vendor"}))
+	assert.Equal(t, []string{"a.go:1:1:Foo foobar"},
+		grep(GrepQuery{Pattern: "foo", Options: SearchOptions{WholeWord: true}, Globs: "*.go !vendor"}))
+	assert.Equal(t, []string{filepath.FromSlash("sub/b.go") + ":1:1:f.o"},
+		grep(GrepQuery{Pattern: "f.o", Options: SearchOptions{Literal: true, CaseSensitive: true}}))
+	assert.Equal(t, []string{"a.txt:1:1:foo"},
+		grep(GrepQuery{Pattern: "foo", Options: SearchOptions{CaseSensitive: true}, Globs: "!*.go"}))
+}
+
+func TestRipgrepArgs(t *testing.T) {
+	assert.Equal(t, []string{"--vimgrep", "--ignore-case", "--fixed-strings", "--word-regexp", "--glob", "*.go", "--glob", "!vendor", "--regexp", "-x"},
+		GrepQuery{Pattern: "-x", Options: SearchOptions{Literal: true, WholeWord: true}, Globs: "*.go !vendor"}.RipgrepArgs())
+}
+
+func TestGrepHistory(t *testing.T) {
+	var h PromptHistory[GrepQuery]
+	h.Add(GrepQuery{Pattern: "a"})
+	h.Add(GrepQuery{Pattern: "b"})
+	h.Add(GrepQuery{Pattern: "a"})
+	assert.Equal(t, []GrepQuery{{Pattern: "b"}, {Pattern: "a"}}, h.Entries)
+
+	q, ok := h.Prev()
+	assert.True(t, ok)
+	assert.Equal(t, "a", q.Pattern)
+	q, _ = h.Prev()
+	assert.Equal(t, "b", q.Pattern)
+	_, ok = h.Prev()
+	assert.False(t, ok)
+	q, ok = h.Next()
+	assert.True(t, ok)
+	assert.Equal(t, "a", q.Pattern)
+	_, ok = h.Next()
+	assert.False(t, ok)
+	q, _ = h.Prev()
+	assert.Equal(t, "a", q.Pattern)
+}
+
+func TestGrepBufferWithoutRipgrep(t *testing.T) {
+	hasRipgrep := HasRipgrep
+	HasRipgrep = func() bool { return false }
+	defer func() { HasRipgrep = hasRipgrep }()
+
+	root := writeTree(t, map[string]string{"a.go": "package a\nvar x = 1\n"})
+	c := newTestContext()
+	c.CWD = root
+	view, err := NewGrepBuffer(c, c.Cfg, GrepQuery{Pattern: "var", Options: SearchOptions{CaseSensitive: true}})
+	assert.NoError(t, err)
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "Finished in")
+	})
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "1 matches in 1 files\n")
+	})
+	assert.Contains(t, string(view.Buffer.Content.Bytes()), "a.go:2:1:var x = 1\n")
+	loc, ok := ParseLocation(c.Cfg.AllErrorFormats(), view.lineBytes(2), view.locationDir())
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(root, "a.go"), loc.File)
+}
+
+func TestGrepPrompt(t *testing.T) {
+	setupDefaults()
+	root := t.TempDir()
+	c := newTestContext()
+	c.CWD = root
+	c.GrepHistory.Add(GrepQuery{Pattern: "old", Globs: "*.go"})
+	GrepAsk(NewBufferView(c, c.Cfg, &Buffer{File: "*scratch*", Content: NewPieceTable(nil)}))
+	assert.Equal(t, "", c.Prompt.UserInput)
+	assert.Equal(t, "Grep [regex] in "+root+" -g *.go", c.Prompt.Text)
+
+	c.Prompt.UserInput = "foo("
+	c.Prompt.ChangeHook(c.Prompt.UserInput, c)
+	assert.NotEmpty(t, c.Prompt.Error)
+	c.Prompt.Keymap[Key{K: "r", Alt: true}](c)
+	assert.Empty(t, c.Prompt.Error)
+	assert.Equal(t, "Grep [literal] in "+root+" -g *.go", c.Prompt.Text)
+
+	c.Prompt.Keymap[Key{K: "g", Alt: true}](c)
+	assert.Equal(t, "*.go", c.Prompt.UserInput)
+	c.Prompt.DoneHook(" *.txt   !vendor ", c)
+	assert.Equal(t, "foo(", c.Prompt.UserInput)
+	assert.Equal(t, "Grep [literal] in "+root+" -g *.txt !vendor", c.Prompt.Text)
+
+	c.Prompt.Keymap[Key{K: "<up>"}](c)
+	assert.Equal(t, "old", c.Prompt.UserInput)
+	assert.Equal(t, "Grep [regex] in "+root+" -g *.go", c.Prompt.Text)
+	c.Prompt.Keymap[Key{K: "<down>"}](c)
+	assert.Equal(t, "", c.Prompt.UserInput)
+}
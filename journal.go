@@ -0,0 +1,184 @@
+package preditor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how often dirty buffers are written to the journal.
+const journalInterval = 5 * time.Second
+
+// Journal is an autosaved copy of a dirty buffer, it's used to recover unsaved changes after a crash.
+type Journal struct {
+	File    string
+	Time    time.Time
+	Content []byte
+
+	path string
+}
+
+func defaultJournalDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "preditor", "journal")
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "preditor", "journal")
+	}
+	return filepath.Join(os.TempDir(), "preditor", "journal")
+}
+
+func journalPath(dir string, file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	sum := sha256.Sum256([]byte(file))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".journal")
+}
+
+func (c *Context) writeJournal(buf *Buffer) error {
+	if err := os.MkdirAll(c.JournalDir, 0700); err != nil {
+		return err
+	}
+	file, err := filepath.Abs(buf.File)
+	if err != nil {
+		return err
+	}
+	bs, err := json.Marshal(Journal{File: file, Time: time.Now(), Content: buf.Content.Bytes()})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(journalPath(c.JournalDir, buf.File), bs, false)
+}
+
+// JournalDirtyBuffers writes every dirty buffer that changed since its last journal, if force is not set it
+// only runs once every journalInterval.
+func (c *Context) JournalDirtyBuffers(force bool) {
+	if c.JournalDir == "" || (!force && time.Since(c.lastJournal) < journalInterval) {
+		return
+	}
+	c.lastJournal = time.Now()
+	for _, buf := range c.Buffers {
+		if buf.State != State_Dirty || buf.Readonly || buf.File == "" || buf.File[0] == '*' {
+			continue
+		}
+		if buf.journaled == buf.Version {
+			continue
+		}
+		if err := c.writeJournal(buf); err != nil {
+			c.WriteMessage(fmt.Sprintf("Error writing journal for %s: %s", buf.File, err))
+			continue
+		}
+		buf.journaled = buf.Version
+	}
+}
+
+func (c *Context) RemoveJournal(buf *Buffer) {
+	if c.JournalDir == "" {
+		return
+	}
+	_ = os.Remove(journalPath(c.JournalDir, buf.File))
+	buf.journaled = -1
+}
+
+func (c *Context) RemoveJournals() {
+	for _, buf := range c.Buffers {
+		c.RemoveJournal(buf)
+	}
+}
+
+// ReadJournals returns journals left in dir, journals that have the same content as their file are removed.
+func ReadJournals(dir string) ([]*Journal, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journals []*Journal
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".journal") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		j := &Journal{path: path}
+		if err := json.Unmarshal(bs, j); err != nil {
+			continue
+		}
+		if disk, err := os.ReadFile(j.File); err == nil && bytes.Equal(bytes.ReplaceAll(disk, []byte("\r\n"), []byte("\n")), j.Content) {
+			_ = os.Remove(path)
+			continue
+		}
+		journals = append(journals, j)
+	}
+	return journals, nil
+}
+
+// RecoverJournals asks for every leftover journal whether it should be recovered.
+func (c *Context) RecoverJournals() {
+	journals, err := ReadJournals(c.JournalDir)
+	if err != nil {
+		c.WriteMessage(fmt.Sprintf("Error reading journals: %s", err))
+		return
+	}
+	c.pendingJournals = journals
+	c.nextJournalPrompt()
+}
+
+func (c *Context) nextJournalPrompt() {
+	if len(c.pendingJournals) == 0 {
+		return
+	}
+	j := c.pendingJournals[0]
+	done := func(c *Context) {
+		c.ResetPrompt()
+		c.pendingJournals = c.pendingJournals[1:]
+		c.nextJournalPrompt()
+	}
+	keymap := Keymap{
+		Key{K: "y"}: func(c *Context) {
+			if err := c.RecoverJournal(j); err != nil {
+				c.ShowMessage(fmt.Sprintf("Error recovering %s: %s", j.File, err))
+			}
+			done(c)
+		},
+		Key{K: "n"}: func(c *Context) {
+			_ = os.Remove(j.path)
+			done(c)
+		},
+		Key{K: "v"}: func(c *Context) {
+			disk, _ := os.ReadFile(j.File)
+			c.OpenDiffBuffer(j.File, "disk", "journal", disk, j.Content)
+		},
+		Key{K: "<esc>"}: done,
+	}
+	c.SetPrompt(fmt.Sprintf("Recover unsaved changes to %s from %s? (y)es, (n)o and discard, (v)iew diff",
+		j.File, j.Time.Format(time.DateTime)), nil, nil, &keymap, "")
+}
+
+// RecoverJournal opens journal's file and replaces its content with the journal, buffer stays dirty so
+// recovery can be undone or saved.
+func (c *Context) RecoverJournal(j *Journal) error {
+	if err := SwitchOrOpenFileInCurrentWindow(c, c.Cfg, j.File, nil); err != nil {
+		return err
+	}
+	view, ok := c.ActiveDrawable().(*BufferView)
+	if !ok {
+		return fmt.Errorf("no buffer for %s", j.File)
+	}
+	view.ReplaceContent(j.Content)
+	view.SetStateDirty()
+	_ = os.Remove(j.path)
+	return nil
+}
@@ -0,0 +1,49 @@
+package preditor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	setupDefaults()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("saved\n"), 0644))
+	c := newTestContext()
+	c.JournalDir = filepath.Join(dir, "journal")
+	view := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(path))
+
+	c.JournalDirtyBuffers(true)
+	journals, err := ReadJournals(c.JournalDir)
+	assert.NoError(t, err)
+	assert.Empty(t, journals)
+
+	BufferInsertChar(view, 'x')
+	c.JournalDirtyBuffers(true)
+	journals, err = ReadJournals(c.JournalDir)
+	assert.NoError(t, err)
+	assert.Len(t, journals, 1)
+	assert.Equal(t, "xsaved\n", string(journals[0].Content))
+
+	c.RecoverJournals()
+	assert.True(t, c.Prompt.IsActive)
+	c.Prompt.Keymap[Key{K: "n"}](c)
+	assert.False(t, c.Prompt.IsActive)
+	journals, _ = ReadJournals(c.JournalDir)
+	assert.Empty(t, journals)
+
+	// journal that matches the file on disk is stale and is dropped.
+	BufferInsertChar(view, 'y')
+	c.JournalDirtyBuffers(true)
+	entries, _ := os.ReadDir(c.JournalDir)
+	assert.Len(t, entries, 1)
+	assert.NoError(t, os.WriteFile(path, []byte("xysaved\n"), 0644))
+	journals, _ = ReadJournals(c.JournalDir)
+	assert.Empty(t, journals)
+	entries, _ = os.ReadDir(c.JournalDir)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,420 @@
+package preditor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrLargeFile is returned when saving a buffer opened in large file mode.
+var ErrLargeFile = errors.New("large files are opened read only")
+
+// ErrFileTruncated is returned when reading a memory mapped file that was truncated after it was opened.
+var ErrFileTruncated = errors.New("file was truncated on disk")
+
+const (
+	lazyLineCheckpoint  = 64      // start of every 64th line is kept, lines in between are found by scanning
+	lazyIndexStep       = 8 << 20 // bytes indexed every frame in background
+	lazyScanChunk       = 4 << 10 // bytes copied out of the file at once when scanning
+	lazyBoundsCache     = 1024    // lines whose start and end are remembered
+	streamSearchChunk   = 4 << 20
+	streamSearchOverlap = 64 << 10 // longest match a streaming search can find
+)
+
+// MappedText is read only TextStorage over a memory mapped file, pages are read by the OS when they are
+// accessed so nothing is loaded up front. The mapping itself is never handed out, every read copies from it
+// so when the file is truncated by another program the fault happens inside ReadAt, where it's recovered,
+// and not wherever a slice of it ends up. Close releases the mapping, Buffer.Reset does it when content of a
+// large file buffer is replaced.
+type MappedText struct {
+	mu        sync.RWMutex // held for reading while copying so streaming search can't race with Close
+	data      []byte
+	truncated atomic.Bool
+}
+
+func NewMappedText(data []byte) *MappedText {
+	return &MappedText{data: data}
+}
+
+// ReadAt copies mapped content at off into p. Touching pages past the end of a truncated file is a SIGBUS,
+// it's turned into a panic and recovered so ErrFileTruncated is returned instead of crashing the editor.
+func (t *MappedText) ReadAt(p []byte, off int64) (n int, err error) {
+	if t.truncated.Load() {
+		return 0, ErrFileTruncated
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if off >= int64(len(t.data)) {
+		return 0, io.EOF
+	}
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			t.truncated.Store(true)
+			n, err = 0, ErrFileTruncated
+		}
+	}()
+	n = copy(p, t.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Truncated reports if file was found truncated, content that couldn't be read is returned as zeros.
+func (t *MappedText) Truncated() bool {
+	return t.truncated.Load()
+}
+
+func (t *MappedText) Len() int {
+	return len(t.data)
+}
+
+func (t *MappedText) At(idx int) byte {
+	var b [1]byte
+	t.ReadAt(b[:], int64(idx))
+	return b[0]
+}
+
+func (t *MappedText) Slice(start int, end int) []byte {
+	start, end = max(start, 0), min(end, len(t.data))
+	if start >= end {
+		return nil
+	}
+	buf := make([]byte, end-start)
+	t.ReadAt(buf, int64(start))
+	return buf
+}
+
+func (t *MappedText) Chunk(idx int) []byte {
+	return t.Slice(idx, idx+lazyScanChunk)
+}
+
+// Bytes copies the whole file, large file code paths read it in chunks instead.
+func (t *MappedText) Bytes() []byte {
+	return t.Slice(0, len(t.data))
+}
+
+// Insert does nothing, large file buffers are read only.
+func (t *MappedText) Insert(idx int, data []byte) {}
+
+// Delete does nothing, large file buffers are read only.
+func (t *MappedText) Delete(start int, end int) {}
+
+// Reset does nothing, large file buffers are read only. Buffer.Reset replaces MappedText instead.
+func (t *MappedText) Reset(data []byte) {}
+
+// Close unmaps the file, content is empty after.
+func (t *MappedText) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := unmapFile(t.data)
+	t.data = nil
+	return err
+}
+
+// lazyLines is line index of read only content that is built as far as queries need it, and a few megabytes
+// every frame in background. Until it's done LineCount is number of lines found so far. Content is read in
+// chunks through r, a failed read leaves zeros so what can't be read has no line breaks.
+type lazyLines struct {
+	r           io.ReaderAt
+	size        int
+	buf         []byte
+	checkpoints []int          // checkpoints[i] is start of line i*lazyLineCheckpoint
+	count       int            // number of lines whose start is known
+	indexed     int            // every '\n' before indexed is counted
+	bounds      map[int][2]int // start and end of recently used lines, so long lines aren't scanned every frame
+}
+
+func newLazyLines(r io.ReaderAt, size int) *lazyLines {
+	return &lazyLines{r: r, size: size, buf: make([]byte, lazyScanChunk), checkpoints: []int{0}, count: 1, bounds: map[int][2]int{}}
+}
+
+func (l *lazyLines) done() bool {
+	return l.indexed == l.size
+}
+
+// read returns content in [start, min(end, start+lazyScanChunk)), it's valid until next read.
+func (l *lazyLines) read(start int, end int) []byte {
+	chunk := l.buf[:min(end-start, lazyScanChunk)]
+	clear(chunk)
+	l.r.ReadAt(chunk, int64(start))
+	return chunk
+}
+
+// skipLines returns offset after n-th '\n' at or after from, or -1 if there are fewer.
+func (l *lazyLines) skipLines(from int, n int) int {
+	for from < l.size {
+		chunk := l.read(from, l.size)
+		for i := 0; ; n-- {
+			if n == 0 {
+				return from + i
+			}
+			j := bytes.IndexByte(chunk[i:], '\n')
+			if j == -1 {
+				break
+			}
+			i += j + 1
+		}
+		from += len(chunk)
+	}
+	return -1
+}
+
+// indexTo counts line breaks up to offset.
+func (l *lazyLines) indexTo(offset int) {
+	offset = min(offset, l.size)
+	for l.indexed < offset {
+		start := l.indexed
+		chunk := l.read(start, offset)
+		for i := 0; ; {
+			j := bytes.IndexByte(chunk[i:], '\n')
+			if j == -1 {
+				break
+			}
+			i += j + 1
+			if l.count%lazyLineCheckpoint == 0 {
+				l.checkpoints = append(l.checkpoints, start+i)
+			}
+			l.count++
+		}
+		l.indexed = start + len(chunk)
+	}
+}
+
+// indexLine indexes until start of line is known or content ends.
+func (l *lazyLines) indexLine(line int) {
+	for line >= l.count && !l.done() {
+		l.indexTo(l.indexed + lazyIndexStep)
+	}
+}
+
+// indexStep indexes next lazyIndexStep bytes and reports if whole content is indexed.
+func (l *lazyLines) indexStep() bool {
+	l.indexTo(l.indexed + lazyIndexStep)
+	return l.done()
+}
+
+// lineBounds returns start and end of a line whose start is known, content never changes so they are cached.
+func (l *lazyLines) lineBounds(line int) (int, int) {
+	if b, ok := l.bounds[line]; ok {
+		return b[0], b[1]
+	}
+	start := l.skipLines(l.checkpoints[line/lazyLineCheckpoint], line%lazyLineCheckpoint)
+	end := l.size
+	if i := l.skipLines(start, 1); i != -1 {
+		end = i - 1
+	}
+	if len(l.bounds) >= lazyBoundsCache {
+		clear(l.bounds)
+	}
+	l.bounds[line] = [2]int{start, end}
+	return start, end
+}
+
+func (l *lazyLines) LineStart(line int) int {
+	if line < 0 {
+		return 0
+	}
+	l.indexLine(line)
+	if line >= l.count {
+		return l.size
+	}
+	start, _ := l.lineBounds(line)
+	return start
+}
+
+func (l *lazyLines) LineEnd(line int) int {
+	l.indexLine(max(line, 0))
+	if line >= l.count {
+		return l.size
+	}
+	_, end := l.lineBounds(max(line, 0))
+	return end
+}
+
+func (l *lazyLines) LineForOffset(offset int) int {
+	if offset <= 0 {
+		return 0
+	}
+	offset = min(offset, l.size)
+	l.indexTo(offset)
+	i := sort.Search(len(l.checkpoints), func(i int) bool { return l.checkpoints[i] > offset }) - 1
+	line := i * lazyLineCheckpoint
+	for pos := l.checkpoints[i]; pos < offset; {
+		chunk := l.read(pos, offset)
+		line += bytes.Count(chunk, []byte("\n"))
+		pos += len(chunk)
+	}
+	return min(line, l.count-1)
+}
+
+func (l *lazyLines) PositionToOffset(pos Position) int {
+	l.indexLine(pos.Line)
+	if pos.Line >= l.count {
+		return l.size
+	}
+	return min(l.LineStart(pos.Line)+pos.Column, l.LineEnd(pos.Line))
+}
+
+// largeFileStatus is shown in statusbar of large file buffers.
+func (b *Buffer) largeFileStatus() string {
+	lines := b.Lines()
+	if mapped, ok := b.Content.(*MappedText); ok && mapped.Truncated() {
+		return "[read only, file was truncated on disk]"
+	}
+	if lines.lazy == nil || lines.lazy.done() {
+		return "[read only]"
+	}
+	return fmt.Sprintf("[read only, indexing lines %d%%]", lines.lazy.indexed*100/max(lines.lazy.size, 1))
+}
+
+// openLargeFile maps file into memory instead of reading it. Buffer is read only, has no syntax highlighting
+// and its lines are indexed when they are needed and a few megabytes every frame.
+func (c *Context) openLargeFile(filename string, size int64) (*Buffer, error) {
+	buf := &Buffer{
+		File:     filename,
+		State:    State_Clean,
+		Readonly: true,
+		Large:    true,
+	}
+	if err := c.mapLargeFile(buf, size); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// mapLargeFile makes first size bytes of buf.File content of buf, mapping it had before is released. It's
+// how large files are opened and reverted, their content is never read as a whole.
+func (c *Context) mapLargeFile(buf *Buffer, size int64) error {
+	f, err := os.Open(buf.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var data []byte
+	// empty files can't be mapped.
+	if size > 0 {
+		data, err = mapFile(f, int(size))
+		if err != nil {
+			return err
+		}
+	}
+	if old, ok := buf.Content.(*MappedText); ok {
+		old.Close()
+	}
+	text := NewMappedText(data)
+	buf.Content = text
+	index := &LineIndex{lazy: newLazyLines(text, text.Len())}
+	buf.lineIndex = index
+	buf.Version++
+	var step func(c *Context)
+	step = func(c *Context) {
+		if buf.lineIndex == index && !index.lazy.indexStep() {
+			c.Post(step)
+		}
+	}
+	c.Post(step)
+	return nil
+}
+
+// StreamSearch returns [start, end] of first non-empty match of re starting in [from, to) of r, end is
+// inclusive. r is read in chunks so memory use doesn't depend on its size, matches longer than
+// streamSearchOverlap are not found. nil is returned when there is no match.
+func StreamSearch(ctx context.Context, r io.ReaderAt, from int, to int, re *regexp.Regexp) ([]int, error) {
+	buf := make([]byte, 1+streamSearchChunk+streamSearchOverlap)
+	for pos := max(from, 0); pos < to; pos += streamSearchChunk {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// one byte before pos is read too so ^ and \b see what's before the window.
+		prefix := min(pos, 1)
+		end := min(pos+streamSearchChunk+streamSearchOverlap, to)
+		n, err := r.ReadAt(buf[:prefix+end-pos], int64(pos-prefix))
+		last := end == to
+		if errors.Is(err, io.EOF) {
+			last = true
+		} else if err != nil {
+			return nil, err
+		}
+		window := buf[:n]
+		loc := re.FindIndex(window)
+		if loc != nil && (loc[0] < prefix || loc[1] == loc[0]) {
+			loc = nil
+			for _, l := range re.FindAllIndex(window, -1) {
+				if l[0] >= prefix && l[1] > l[0] {
+					loc = l
+					break
+				}
+			}
+		}
+		// matches starting in the overlap are left to next window, one reaching end of window may be cut short.
+		if loc != nil && (last || loc[0] < prefix+streamSearchChunk && loc[1] < len(window)) {
+			return []int{pos - prefix + loc[0], pos - prefix + loc[1] - 1}, nil
+		}
+		if last {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// stopStream cancels streaming search in progress.
+func (s *Search) stopStream() {
+	if s.stream != nil {
+		s.stream()
+		s.stream = nil
+	}
+}
+
+// searchStream finds next match starting at from in background, used instead of matching whole content for
+// large files. Found matches are appended to SearchMatches so they can be visited again.
+func (e *BufferView) searchStream(from int) {
+	e.Search.stopStream()
+	ctx, cancel := context.WithCancel(context.Background())
+	e.Search.stream = cancel
+	to := e.Buffer.Content.Len()
+	if e.Search.Region != nil {
+		from, to = max(from, e.Search.Region[0]), e.Search.Region[1]
+	}
+	re, generation, c := e.Search.pattern, e.Search.generation, e.parent
+	r, ok := e.Buffer.Content.(io.ReaderAt)
+	if !ok {
+		r = bytes.NewReader(e.Buffer.Content.Bytes())
+	}
+	go func() {
+		match, err := StreamSearch(ctx, r, from, to, re)
+		c.Post(func(c *Context) {
+			if e.Search.generation != generation || ctx.Err() != nil {
+				return
+			}
+			e.Search.stream = nil
+			switch {
+			case err != nil:
+				c.ShowMessage(fmt.Sprintf("Search: %s", err))
+			case match == nil:
+				e.Search.streamDone = true
+				if len(e.Search.SearchMatches) > 0 {
+					e.Search.CurrentMatch = 0
+					e.Search.MovedAwayFromCurrentMatch = false
+					c.ShowMessage("Search reached end of file, back to first match")
+				}
+			default:
+				e.Search.SearchMatches = append(e.Search.SearchMatches, match)
+				e.Search.CurrentMatch = len(e.Search.SearchMatches) - 1
+				e.Search.MovedAwayFromCurrentMatch = false
+			}
+		})
+	}()
+}
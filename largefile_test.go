@@ -0,0 +1,188 @@
+package preditor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazyLines(t *testing.T) {
+	var sb strings.Builder
+	// long enough to be read in a few chunks.
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "line %d %s\n", i, strings.Repeat("x", i%7))
+	}
+	sb.WriteString("no newline at end")
+	content := []byte(sb.String())
+	eager := NewLineIndex(content)
+
+	lazy := newLazyLines(bytes.NewReader(content), len(content))
+	mid := eager.LineStart(300) + 3
+	assert.Equal(t, 300, lazy.LineForOffset(mid))
+	assert.Equal(t, 301, lazy.count)
+	assert.Equal(t, eager.LineStart(450), lazy.LineStart(450))
+
+	assert.True(t, lazy.indexStep())
+	li := &LineIndex{lazy: lazy}
+	assert.Equal(t, eager.LineCount(), li.LineCount())
+	assert.Equal(t, eager.Len(), li.Len())
+	for line := 0; line <= eager.LineCount(); line++ {
+		assert.Equal(t, eager.LineStart(line), li.LineStart(line), "start of %d", line)
+		assert.Equal(t, eager.LineEnd(line), li.LineEnd(line), "end of %d", line)
+		assert.Equal(t, eager.PositionToOffset(Position{Line: line, Column: 5}), li.PositionToOffset(Position{Line: line, Column: 5}))
+	}
+	for offset := 0; offset <= len(content); offset += 7 {
+		assert.Equal(t, eager.OffsetToPosition(offset), li.OffsetToPosition(offset), "offset %d", offset)
+	}
+
+	trailing := newLazyLines(strings.NewReader("a\nb\n"), 4)
+	trailing.indexStep()
+	assert.Equal(t, 3, trailing.count)
+}
+
+func TestStreamSearch(t *testing.T) {
+	content := bytes.Repeat([]byte("."), 2*streamSearchChunk+100)
+	copy(content[streamSearchChunk-2:], "needle")
+	copy(content[2*streamSearchChunk+50:], "needle")
+	r := bytes.NewReader(content)
+	ctx := context.Background()
+
+	m, err := StreamSearch(ctx, r, 0, len(content), regexp.MustCompile("needle"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{streamSearchChunk - 2, streamSearchChunk + 3}, m)
+
+	m, _ = StreamSearch(ctx, r, streamSearchChunk-1, len(content), regexp.MustCompile("needle"))
+	assert.Equal(t, []int{2*streamSearchChunk + 50, 2*streamSearchChunk + 55}, m)
+
+	m, _ = StreamSearch(ctx, r, streamSearchChunk-1, 2*streamSearchChunk+52, regexp.MustCompile("needle"))
+	assert.Nil(t, m)
+
+	// windows don't start a new text for anchors.
+	m, _ = StreamSearch(ctx, r, streamSearchChunk, len(content), regexp.MustCompile(`^\.`))
+	assert.Nil(t, m)
+	m, _ = StreamSearch(ctx, r, streamSearchChunk-1, len(content), regexp.MustCompile(`\bneedle`))
+	assert.Equal(t, 2*streamSearchChunk+50, m[0])
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = StreamSearch(cancelled, r, 0, len(content), regexp.MustCompile("needle"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOpenLargeFile(t *testing.T) {
+	setupDefaults()
+	path := filepath.Join(t.TempDir(), "big.log")
+	assert.NoError(t, os.WriteFile(path, []byte("foo 1\nbar\nfoo 2\n"), 0644))
+	c := newTestContext()
+	cfg := defaultConfig
+	cfg.LargeFileThreshold = 10
+	c.Cfg = &cfg
+
+	buf := c.OpenFileAsBuffer(path)
+	assert.True(t, buf.Large)
+	assert.True(t, buf.Readonly)
+	assert.IsType(t, &MappedText{}, buf.Content)
+	assert.Nil(t, buf.fileType.TSLanguage)
+	runUntil(t, c, func() bool { return buf.Lines().lazy.done() })
+	assert.Equal(t, 4, buf.Lines().LineCount())
+	assert.Equal(t, "[read only]", buf.largeFileStatus())
+
+	view := NewBufferView(c, c.Cfg, buf)
+	c.AddDrawable(view)
+	buf.Insert(0, []byte("x"))
+	assert.Equal(t, "foo 1\nbar\nfoo 2\n", string(buf.Content.Bytes()))
+	assert.ErrorIs(t, view.Save(), ErrLargeFile)
+
+	SearchActivate(view)
+	c.Prompt.DoneHook("foo", c)
+	runUntil(t, c, func() bool { return len(view.Search.SearchMatches) == 1 })
+	assert.Equal(t, "match 1/1+", view.Search.MatchCount())
+	SearchNextMatch(view)
+	runUntil(t, c, func() bool { return len(view.Search.SearchMatches) == 2 })
+	assert.Equal(t, [][]int{{0, 2}, {10, 12}}, view.Search.SearchMatches)
+	SearchNextMatch(view)
+	runUntil(t, c, func() bool { return view.Search.streamDone })
+	assert.Equal(t, "match 1/2", view.Search.MatchCount())
+	SearchExit(view)
+	assert.Nil(t, view.Search.pattern)
+
+	mapped := buf.Content.(*MappedText)
+	assert.NoError(t, os.WriteFile(path, []byte("reverted from disk\n"), 0644))
+	RevertBuffer(view)
+	assert.True(t, buf.Large)
+	assert.Equal(t, 0, mapped.Len())
+	assert.Equal(t, "reverted from disk\n", string(buf.Content.Bytes()))
+	runUntil(t, c, func() bool { return buf.Lines().lazy.done() })
+	assert.Equal(t, 2, buf.Lines().LineCount())
+
+	mapped = buf.Content.(*MappedText)
+	buf.Reset([]byte("replaced"))
+	assert.False(t, buf.Large)
+	assert.Equal(t, 0, mapped.Len())
+	assert.Equal(t, "replaced", string(buf.Content.Bytes()))
+
+	cfg.LargeFileThreshold = 0
+	small := filepath.Join(t.TempDir(), "small.log")
+	assert.NoError(t, os.WriteFile(small, []byte("foo 1\nbar\nfoo 2\n"), 0644))
+	assert.False(t, c.OpenFileAsBuffer(small).Large)
+}
+
+func TestMappedTextTruncated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mapped files can't be truncated on windows")
+	}
+	path := filepath.Join(t.TempDir(), "big.log")
+	assert.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("line\n"), 4096), 0644))
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	data, err := mapFile(f, 4096*5)
+	f.Close()
+	assert.NoError(t, err)
+	text := NewMappedText(data)
+	assert.Equal(t, []byte("line\n"), text.Slice(5, 10))
+
+	assert.NoError(t, os.Truncate(path, 0))
+	_, err = text.ReadAt(make([]byte, 10), 8192)
+	assert.ErrorIs(t, err, ErrFileTruncated)
+	assert.True(t, text.Truncated())
+	assert.Equal(t, make([]byte, 5), text.Slice(5, 10))
+	buf := &Buffer{Large: true, Content: text, lineIndex: &LineIndex{lazy: newLazyLines(text, text.Len())}}
+	assert.Equal(t, 0, buf.Lines().LineForOffset(text.Len()))
+	assert.Equal(t, "[read only, file was truncated on disk]", buf.largeFileStatus())
+	assert.NoError(t, text.Close())
+}
+
+func TestLazyLinesLongLines(t *testing.T) {
+	content := []byte(strings.Repeat("x", 3*lazyScanChunk+10) + "\n" + strings.Repeat("y", lazyScanChunk) + "\nz")
+	r := &countingReader{r: bytes.NewReader(content)}
+	lazy := newLazyLines(r, len(content))
+	assert.Equal(t, 3*lazyScanChunk+10, lazy.LineEnd(0))
+	assert.Equal(t, 3*lazyScanChunk+11, lazy.LineStart(1))
+	assert.Equal(t, len(content)-2, lazy.LineEnd(1))
+	reads := r.reads
+	for i := 0; i < 10; i++ {
+		lazy.LineStart(1)
+		lazy.LineEnd(0)
+		lazy.LineEnd(1)
+	}
+	assert.Equal(t, reads, r.reads)
+}
+
+type countingReader struct {
+	r     io.ReaderAt
+	reads int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
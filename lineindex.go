@@ -0,0 +1,298 @@
+package preditor
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+// LineIndex keeps length of every line of a buffer in a treap ordered by line, every node knows number of
+// lines and bytes under it so offset <-> line queries and edits inside a line are O(log n). Edits adding or
+// removing k line breaks split and merge the tree around them in O(k + log n).
+// Large files use lazy instead, see lazyLines.
+type LineIndex struct {
+	root *lineNode
+	lazy *lazyLines
+}
+
+type lineNode struct {
+	left, right *lineNode
+	priority    uint32
+	length      int // length of the line including the trailing '\n'
+	lines       int // number of lines in subtree
+	size        int // sum of lengths in subtree
+}
+
+func (n *lineNode) update() {
+	n.lines, n.size = 1, n.length
+	if n.left != nil {
+		n.lines += n.left.lines
+		n.size += n.left.size
+	}
+	if n.right != nil {
+		n.lines += n.right.lines
+		n.size += n.right.size
+	}
+}
+
+func lineCount(n *lineNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.lines
+}
+
+func lineBytes(n *lineNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// buildNodes builds a tree of lengths in O(n), nodes are added along the right spine keeping heap order of
+// priorities.
+func buildNodes(lengths []int) *lineNode {
+	var spine []*lineNode
+	for _, l := range lengths {
+		n := &lineNode{priority: rand.Uint32(), length: l}
+		var last *lineNode
+		for len(spine) > 0 && spine[len(spine)-1].priority < n.priority {
+			last = spine[len(spine)-1]
+			spine = spine[:len(spine)-1]
+			last.update()
+		}
+		n.left = last
+		if len(spine) > 0 {
+			spine[len(spine)-1].right = n
+		}
+		spine = append(spine, n)
+	}
+	for i := len(spine) - 1; i >= 0; i-- {
+		spine[i].update()
+	}
+	if len(spine) == 0 {
+		return nil
+	}
+	return spine[0]
+}
+
+// splitNodes splits n into first k lines and the rest.
+func splitNodes(n *lineNode, k int) (*lineNode, *lineNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if k <= lineCount(n.left) {
+		l, r := splitNodes(n.left, k)
+		n.left = r
+		n.update()
+		return l, n
+	}
+	l, r := splitNodes(n.right, k-lineCount(n.left)-1)
+	n.right = l
+	n.update()
+	return n, r
+}
+
+func mergeNodes(a *lineNode, b *lineNode) *lineNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if a.priority > b.priority {
+		a.right = mergeNodes(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = mergeNodes(a, b.left)
+	b.update()
+	return b
+}
+
+func NewLineIndex(content []byte) *LineIndex {
+	li := &LineIndex{}
+	li.Reset(content)
+	return li
+}
+
+func (li *LineIndex) Reset(content []byte) {
+	li.root = buildNodes(lineLengths(content, 0, 0))
+}
+
+// lineLengths returns lengths of lines of data, head is added to the first one and tail to the last one.
+func lineLengths(data []byte, head int, tail int) []int {
+	lengths := make([]int, 0, bytes.Count(data, []byte("\n"))+1)
+	start := 0
+	for {
+		i := bytes.IndexByte(data[start:], '\n')
+		if i == -1 {
+			break
+		}
+		lengths = append(lengths, i+1)
+		start += i + 1
+	}
+	lengths = append(lengths, len(data)-start)
+	lengths[0] += head
+	lengths[len(lengths)-1] += tail
+	return lengths
+}
+
+// add changes length of line by delta.
+func (li *LineIndex) add(line int, delta int) {
+	for n := li.root; n != nil; {
+		n.size += delta
+		switch left := lineCount(n.left); {
+		case line < left:
+			n = n.left
+		case line == left:
+			n.length += delta
+			return
+		default:
+			line -= left + 1
+			n = n.right
+		}
+	}
+}
+
+// lineLength returns length of line including its '\n'.
+func (li *LineIndex) lineLength(line int) int {
+	for n := li.root; n != nil; {
+		switch left := lineCount(n.left); {
+		case line < left:
+			n = n.left
+		case line == left:
+			return n.length
+		default:
+			line -= left + 1
+			n = n.right
+		}
+	}
+	return 0
+}
+
+// prefix returns sum of lengths of first n lines.
+func (li *LineIndex) prefix(line int) int {
+	var sum int
+	for n := li.root; n != nil; {
+		switch left := lineCount(n.left); {
+		case line < left:
+			n = n.left
+		case line == left:
+			return sum + lineBytes(n.left)
+		default:
+			sum += lineBytes(n.left) + n.length
+			line -= left + 1
+			n = n.right
+		}
+	}
+	return sum
+}
+
+func (li *LineIndex) LineCount() int {
+	if li.lazy != nil {
+		return li.lazy.count
+	}
+	return lineCount(li.root)
+}
+
+func (li *LineIndex) Len() int {
+	if li.lazy != nil {
+		return li.lazy.size
+	}
+	return lineBytes(li.root)
+}
+
+func (li *LineIndex) LineStart(line int) int {
+	if li.lazy != nil {
+		return li.lazy.LineStart(line)
+	}
+	if line < 0 {
+		return 0
+	}
+	if line >= li.LineCount() {
+		return li.Len()
+	}
+	return li.prefix(line)
+}
+
+// LineEnd returns index of the '\n' ending the line, or end of buffer for last line.
+func (li *LineIndex) LineEnd(line int) int {
+	if li.lazy != nil {
+		return li.lazy.LineEnd(line)
+	}
+	if line >= li.LineCount()-1 {
+		return li.Len()
+	}
+	return li.prefix(line+1) - 1
+}
+
+func (li *LineIndex) LineForOffset(offset int) int {
+	if li.lazy != nil {
+		return li.lazy.LineForOffset(offset)
+	}
+	if offset <= 0 {
+		return 0
+	}
+	// find largest line whose start is <= offset
+	line := 0
+	for n := li.root; n != nil; {
+		switch left := lineBytes(n.left); {
+		case offset < left:
+			n = n.left
+		case offset < left+n.length:
+			return line + lineCount(n.left)
+		default:
+			offset -= left + n.length
+			line += lineCount(n.left) + 1
+			n = n.right
+		}
+	}
+	return max(line-1, 0)
+}
+
+func (li *LineIndex) OffsetToPosition(offset int) Position {
+	line := li.LineForOffset(offset)
+	return Position{Line: line, Column: offset - li.LineStart(line)}
+}
+
+func (li *LineIndex) PositionToOffset(pos Position) int {
+	if li.lazy != nil {
+		return li.lazy.PositionToOffset(pos)
+	}
+	if pos.Line >= li.LineCount() {
+		return li.Len()
+	}
+	offset := li.LineStart(pos.Line) + pos.Column
+	if end := li.LineEnd(pos.Line); offset > end {
+		offset = end
+	}
+	return offset
+}
+
+func (li *LineIndex) Insert(offset int, data []byte) {
+	line := li.LineForOffset(offset)
+	if bytes.IndexByte(data, '\n') == -1 {
+		li.add(line, len(data))
+		return
+	}
+	head := offset - li.LineStart(line)
+	tail := li.lineLength(line) - head
+	before, rest := splitNodes(li.root, line)
+	_, after := splitNodes(rest, 1)
+	li.root = mergeNodes(mergeNodes(before, buildNodes(lineLengths(data, head, tail))), after)
+}
+
+// Delete updates index for removal of [start, end), deleted is the removed data.
+func (li *LineIndex) Delete(start int, end int, deleted []byte) {
+	line := li.LineForOffset(start)
+	newlines := bytes.Count(deleted, []byte("\n"))
+	if newlines == 0 {
+		li.add(line, -(end - start))
+		return
+	}
+	before, rest := splitNodes(li.root, line)
+	joined, after := splitNodes(rest, newlines+1)
+	merged := &lineNode{priority: rand.Uint32(), length: lineBytes(joined) - (end - start)}
+	merged.update()
+	li.root = mergeNodes(mergeNodes(before, merged), after)
+}
@@ -0,0 +1,93 @@
+package preditor
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func assertLineIndexMatches(t *testing.T, content []byte, li *LineIndex) {
+	t.Helper()
+	lines := bytes.Split(content, []byte("\n"))
+	assert.Equal(t, len(lines), li.LineCount())
+	assert.Equal(t, len(content), li.Len())
+	start := 0
+	for i, line := range lines {
+		assert.Equal(t, start, li.LineStart(i))
+		assert.Equal(t, start+len(line), li.LineEnd(i))
+		assert.Equal(t, i, li.LineForOffset(start))
+		assert.Equal(t, Position{Line: i, Column: len(line)}, li.OffsetToPosition(start+len(line)))
+		start += len(line) + 1
+	}
+}
+
+func TestLineIndex(t *testing.T) {
+	content := []byte("first\nsecond\n\nfourth")
+	li := NewLineIndex(content)
+	assertLineIndexMatches(t, content, li)
+	assert.Equal(t, 13, li.PositionToOffset(Position{Line: 2, Column: 10}))
+	assert.Equal(t, len(content), li.PositionToOffset(Position{Line: 10}))
+
+	li = NewLineIndex(nil)
+	assert.Equal(t, 1, li.LineCount())
+	assert.Equal(t, 0, li.LineEnd(0))
+}
+
+func TestLineIndexRandomEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	content := []byte("package main\n\nfunc main() {}\n")
+	li := NewLineIndex(content)
+	for i := 0; i < 1000; i++ {
+		idx := r.Intn(len(content) + 1)
+		if r.Intn(3) == 0 {
+			end := min(idx+r.Intn(10), len(content))
+			li.Delete(idx, end, content[idx:end])
+			content = append(content[:idx:idx], content[end:]...)
+		} else {
+			data := []byte(fmt.Sprint(i))
+			if r.Intn(2) == 0 {
+				data = append(data, '\n')
+			}
+			li.Insert(idx, data)
+			content = append(content[:idx:idx], append(data, content[idx:]...)...)
+		}
+		assertLineIndexMatches(t, content, li)
+	}
+}
+
+func lineDepth(n *lineNode) int {
+	if n == nil {
+		return 0
+	}
+	return 1 + max(lineDepth(n.left), lineDepth(n.right))
+}
+
+func TestLineIndexKeepsNodes(t *testing.T) {
+	content := bytes.Repeat([]byte("some line\n"), 100000)
+	li := NewLineIndex(content)
+	first := li.root
+	for first.left != nil {
+		first = first.left
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		idx := 10 + r.Intn(len(content)-10)
+		li.Insert(idx, []byte("a\nb\n"))
+		content = append(content[:idx:idx], append([]byte("a\nb\n"), content[idx:]...)...)
+		li.Delete(idx, idx+2, content[idx:idx+2])
+		content = append(content[:idx:idx], content[idx+2:]...)
+	}
+	assert.Equal(t, bytes.Count(content, []byte("\n"))+1, li.LineCount())
+	assert.Equal(t, len(content), li.Len())
+	// lines that weren't touched keep their nodes and tree stays balanced.
+	leftmost := li.root
+	for leftmost.left != nil {
+		leftmost = leftmost.left
+	}
+	assert.Same(t, first, leftmost)
+	assert.Less(t, lineDepth(li.root), 60)
+	assertLineIndexMatches(t, content, li)
+}
@@ -8,6 +8,9 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 	"github.com/lithammer/fuzzysearch/fuzzy"
@@ -58,8 +61,8 @@ func (l *List[T]) SetNewUserInput(bs []byte) {
 	}
 
 }
-func (l *List[T]) InsertCharAtBuffer(char byte) error {
-	l.SetNewUserInput(append(l.UserInput, char))
+func (l *List[T]) InsertCharAtBuffer(char rune) error {
+	l.SetNewUserInput(utf8.AppendRune(l.UserInput, char))
 	return nil
 }
 
@@ -132,10 +135,11 @@ func (l *List[T]) DeleteCharBackward() error {
 	if l.Idx <= 0 {
 		return nil
 	}
+	_, size := utf8.DecodeLastRune(l.UserInput[:l.Idx])
 	if len(l.UserInput) <= l.Idx {
-		l.SetNewUserInput(l.UserInput[:l.Idx-1])
+		l.SetNewUserInput(l.UserInput[:l.Idx-size])
 	} else {
-		l.SetNewUserInput(append(l.UserInput[:l.Idx-1], l.UserInput[l.Idx:]...))
+		l.SetNewUserInput(append(l.UserInput[:l.Idx-size], l.UserInput[l.Idx:]...))
 	}
 	return nil
 }
@@ -248,8 +252,8 @@ func NewList[T any](
 		ifb.Items = iList
 	}
 
-	ifb.keymaps = append(ifb.keymaps, MakeInsertionKeys(func(c *Context, b byte) {
-		ifb.InsertCharAtBuffer(b)
+	ifb.keymaps = append(ifb.keymaps, MakeInsertionKeys(func(c *Context, r rune) {
+		ifb.InsertCharAtBuffer(r)
 	}))
 	return ifb
 }
@@ -474,7 +478,7 @@ func NewFuzzyFileList(parent *Context, cfg *Config, cwd string) *List[ScoredItem
 
 	initialList := func() []ScoredItem[LocationItem] {
 		var locationItems []ScoredItem[LocationItem]
-		files := RipgrepFiles(cwd)
+		files := ListFiles(cwd)
 		for _, file := range files {
 			locationItems = append(locationItems, ScoredItem[LocationItem]{Item: LocationItem{Filename: file}})
 		}
@@ -593,3 +597,102 @@ func NewFileList(parent *Context, cfg *Config, initialInput string) *List[Locati
 
 	return ifb
 }
+
+func describeUndoNode(n *UndoNode) string {
+	if len(n.Actions) != 1 {
+		return fmt.Sprintf("%d edits", len(n.Actions))
+	}
+	a := n.Actions[0]
+	data := string(a.Data)
+	if len(data) > 30 {
+		cut := 30
+		for cut > 0 && !utf8.RuneStart(data[cut]) {
+			cut--
+		}
+		data = data[:cut] + "..."
+	}
+	if a.Type == BufferActionType_Insert {
+		return fmt.Sprintf("insert %q", data)
+	}
+	return fmt.Sprintf("delete %q", data)
+}
+
+func NewUndoTreeList(parent *Context, cfg *Config, bufferView *BufferView) *List[*UndoNode] {
+	history := bufferView.Buffer.History()
+	repr := func(n *UndoNode) string {
+		marker := " "
+		if n == history.Current {
+			marker = "*"
+		}
+		var parentSeq int
+		if n.Parent != nil {
+			parentSeq = n.Parent.Seq
+		}
+		return fmt.Sprintf("%s #%d <- #%d %s (%s ago)", marker, n.Seq, parentSeq, describeUndoNode(n), time.Since(n.Time).Round(time.Second))
+	}
+	updateList := func(l *List[*UndoNode], input string) {
+		l.Items = nil
+		nodes := history.Nodes()
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if strings.Contains(repr(nodes[i]), input) {
+				l.Items = append(l.Items, nodes[i])
+			}
+		}
+		if l.Selection >= len(l.Items) {
+			l.Selection = len(l.Items) - 1
+		}
+		if l.Selection < 0 {
+			l.Selection = 0
+		}
+	}
+	openSelection := func(parent *Context, n *UndoNode) error {
+		parent.KillDrawable(parent.ActiveDrawableID())
+		parent.MarkDrawableAsActive(bufferView.ID)
+		bufferView.UndoTo(n)
+		return nil
+	}
+
+	return NewList[*UndoNode](
+		parent,
+		cfg,
+		updateList,
+		openSelection,
+		repr,
+		nil,
+	)
+}
+
+func NewDiagnosticsList(parent *Context, cfg *Config) *List[ScoredItem[*Diagnostic]] {
+	updateList := func(l *List[ScoredItem[*Diagnostic]], input string) {
+		for idx, item := range l.Items {
+			l.Items[idx].Score = fuzzy.RankMatchNormalizedFold(input, item.Item.String())
+		}
+
+		sortme(l.Items, func(t1 ScoredItem[*Diagnostic], t2 ScoredItem[*Diagnostic]) bool {
+			return t1.Score > t2.Score
+		})
+	}
+	openSelection := func(parent *Context, item ScoredItem[*Diagnostic]) error {
+		line, col := item.Item.Position()
+		return SwitchOrOpenFileInCurrentWindow(parent, parent.Cfg, item.Item.File, &Position{Line: line, Column: max(col-1, 0)})
+	}
+	repr := func(item ScoredItem[*Diagnostic]) string {
+		return item.Item.String()
+	}
+	initialList := func() []ScoredItem[*Diagnostic] {
+		var items []ScoredItem[*Diagnostic]
+		for _, d := range parent.Diagnostics {
+			items = append(items, ScoredItem[*Diagnostic]{Item: d})
+		}
+		return items
+	}
+
+	return NewList[ScoredItem[*Diagnostic]](
+		parent,
+		cfg,
+		updateList,
+		openSelection,
+		repr,
+		initialList,
+	)
+}
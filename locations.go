@@ -0,0 +1,237 @@
+package preditor
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// Location is a position in a file parsed from a line of compilation or grep output, Line and Column are
+// 1-based and Column is 0 when output has none. Start and End are the span of the location in output line.
+type Location struct {
+	File     string
+	Line     int
+	Column   int
+	Severity string
+	Start    int
+	End      int
+}
+
+// ErrorFormat recognises locations in a line of output, Regexp must have `file` and `line` named groups and
+// may have `col` and `severity`.
+type ErrorFormat struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+func NewErrorFormat(name string, pattern string) (ErrorFormat, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return ErrorFormat{}, err
+	}
+	if re.SubexpIndex("file") == -1 || re.SubexpIndex("line") == -1 {
+		return ErrorFormat{}, fmt.Errorf("error format %s needs file and line groups", name)
+	}
+	return ErrorFormat{Name: name, Regexp: re}, nil
+}
+
+func mustErrorFormat(name string, pattern string) ErrorFormat {
+	f, err := NewErrorFormat(name, pattern)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// DefaultErrorFormats are tried after formats from config and file types, generic file:line:col comes last.
+var DefaultErrorFormats = []ErrorFormat{
+	mustErrorFormat("python", `^\s*File "(?P<file>[^"]+)", line (?P<line>\d+)`),
+	mustErrorFormat("rustc", `^\s*--> (?P<file>.+?):(?P<line>\d+):(?P<col>\d+)`),
+	mustErrorFormat("msvc", `^\s*(?P<file>(?:[A-Za-z]:)?[^\s(:][^(:]*)\((?P<line>\d+)(?:,(?P<col>\d+))?\)\s*:?\s*(?P<severity>error|warning)?`),
+	mustErrorFormat("gnu", `^\s*(?P<file>(?:[A-Za-z]:)?[^\s:][^:]*?):(?P<line>\d+)(?::(?P<col>\d+))?:\s*(?P<severity>error|warning|note)?`),
+}
+
+// AddErrorFormat registers a user error format, it takes precedence over file type and default formats.
+func (c *Config) AddErrorFormat(name string, pattern string) error {
+	f, err := NewErrorFormat(name, pattern)
+	if err != nil {
+		return err
+	}
+	c.ErrorFormats = append([]ErrorFormat{f}, c.ErrorFormats...)
+	return nil
+}
+
+// AllErrorFormats returns formats in the order they are tried: config, file types then defaults.
+func (c *Config) AllErrorFormats() []ErrorFormat {
+	formats := append([]ErrorFormat(nil), c.ErrorFormats...)
+	exts := make([]string, 0, len(FileTypes))
+	for ext := range FileTypes {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		formats = append(formats, FileTypes[ext].ErrorFormats...)
+	}
+	return append(formats, DefaultErrorFormats...)
+}
+
+// ParseLocation returns location found by first matching format, relative files are joined with dir.
+func ParseLocation(formats []ErrorFormat, line []byte, dir string) (Location, bool) {
+	for _, f := range formats {
+		m := f.Regexp.FindSubmatchIndex(line)
+		if m == nil {
+			continue
+		}
+		group := func(name string) (string, int) {
+			i := f.Regexp.SubexpIndex(name)
+			if i == -1 || m[2*i] == -1 {
+				return "", -1
+			}
+			return string(line[m[2*i]:m[2*i+1]]), m[2*i+1]
+		}
+		file, start := group("file")
+		lineNum, end := group("line")
+		loc := Location{File: file, Start: start - len(file), End: end}
+		var err error
+		if loc.Line, err = strconv.Atoi(lineNum); err != nil || loc.Line < 1 || file == "" {
+			continue
+		}
+		if col, colEnd := group("col"); col != "" {
+			loc.Column, _ = strconv.Atoi(col)
+			loc.End = max(loc.End, colEnd)
+		}
+		loc.Severity, _ = group("severity")
+		if !filepath.IsAbs(loc.File) && !isWindowsAbs(loc.File) {
+			loc.File = filepath.Join(dir, loc.File)
+		}
+		return loc, true
+	}
+	return Location{}, false
+}
+
+func isWindowsAbs(path string) bool {
+	return len(path) > 2 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+}
+
+// LocationList is attached to compilation and grep buffers, it keeps which output line was visited last by
+// next-error and previous-error.
+type LocationList struct {
+	Dir     string
+	Current int // -1 when nothing is visited yet
+}
+
+func newLocationList(dir string) *LocationList {
+	return &LocationList{Dir: dir, Current: -1}
+}
+
+func (e *BufferView) lineBytes(line int) []byte {
+	return e.Buffer.Content.Slice(e.Buffer.Lines().LineStart(line), e.Buffer.Lines().LineEnd(line))
+}
+
+func (e *BufferView) locationDir() string {
+	if e.Locations != nil {
+		return e.Locations.Dir
+	}
+	return e.parent.getCWD()
+}
+
+// locationTargetWindow returns the window locations of output are opened in, it's the active window unless
+// output itself is shown there.
+func (c *Context) locationTargetWindow(output *BufferView) *Window {
+	if win := c.ActiveWindow(); win != nil && win.DrawableID != output.ID && win.ID != c.BuildWindow.ID {
+		return win
+	}
+	for _, col := range c.Windows {
+		for _, win := range col {
+			if win.DrawableID != output.ID {
+				return win
+			}
+		}
+	}
+	return VSplit(c)
+}
+
+// GotoLocation opens location on given line of output in another window and marks it as current.
+func (c *Context) GotoLocation(output *BufferView, line int) bool {
+	if output.occur != nil {
+		return c.gotoOccurrence(output, line)
+	}
+	loc, ok := ParseLocation(c.Cfg.AllErrorFormats(), output.lineBytes(line), output.locationDir())
+	if !ok {
+		return false
+	}
+	if output.Locations != nil {
+		output.Locations.Current = line
+		c.locationOutput = output
+	}
+	output.Cursor.SetBoth(output.Buffer.Lines().LineStart(line))
+	output.ScrollIfNeeded()
+
+	win := c.locationTargetWindow(output)
+	_ = SwitchOrOpenFileInWindow(c, c.Cfg, loc.File, &Position{Line: loc.Line, Column: max(loc.Column-1, 0)}, win)
+	c.ActiveWindowIndex = win.ID
+	return true
+}
+
+// StepLocation visits the next (or previous when step is negative) location in output of the most recent
+// compilation or grep.
+func (c *Context) StepLocation(step int) {
+	output := c.locationOutput
+	if output == nil || c.GetDrawable(output.ID) == nil {
+		c.ShowMessage("No compilation or grep output")
+		return
+	}
+	for line := output.Locations.Current + step; line >= 0 && line < output.Buffer.Lines().LineCount(); line += step {
+		if c.GotoLocation(output, line) {
+			return
+		}
+	}
+	if step > 0 {
+		c.ShowMessage("No more locations")
+	} else {
+		c.ShowMessage("No previous locations")
+	}
+}
+
+func NextLocation(c *Context)     { c.StepLocation(1) }
+func PreviousLocation(c *Context) { c.StepLocation(-1) }
+
+// OpenLocationAtMouse opens location under mouse pointer in a compilation or grep buffer.
+func OpenLocationAtMouse(e *BufferView) {
+	_ = e.moveCursorTo(rl.GetMousePosition())
+	line := e.Buffer.Lines().LineForOffset(e.Cursor.Point)
+	loc, ok := ParseLocation(e.cfg.AllErrorFormats(), e.lineBytes(line), e.locationDir())
+	if !ok {
+		return
+	}
+	if offset := e.Cursor.Point - e.Buffer.Lines().LineStart(line); offset >= loc.Start && offset < loc.End {
+		e.parent.GotoLocation(e, line)
+	}
+}
+
+// underlineLocations underlines locations on visible lines.
+func (e *BufferView) underlineLocations(zeroLocation rl.Vector2, color color.RGBA) {
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	formats := e.cfg.AllErrorFormats()
+	for i, line := range e.visibleLines {
+		if i > 0 && e.visibleLines[i-1].Index == line.Index {
+			continue
+		}
+		loc, ok := ParseLocation(formats, e.lineBytes(line.Index), e.locationDir())
+		if !ok {
+			continue
+		}
+		start := e.Buffer.Lines().LineStart(line.Index)
+		e.visibleSegments(start+loc.Start, start+loc.End, func(row int, segment BufferLine, from int, to int) {
+			col := e.displayColumn(segment.startIndex, from)
+			posX, posY := e.cellPosition(zeroLocation, row, col)
+			width := e.displayColumn(segment.startIndex, to) - col
+			rl.DrawRectangle(posX, posY+int32(charSize.Y)-1, int32(width)*int32(charSize.X), 1, color)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package preditor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		line string
+		want Location
+		ok   bool
+	}{
+		{line: "main.go:12:5: undefined: foo", want: Location{File: "/src/main.go", Line: 12, Column: 5, End: 12}, ok: true},
+		{line: "pkg/a.go:3: missing return", want: Location{File: "/src/pkg/a.go", Line: 3, End: 10}, ok: true},
+		{line: "    a_test.go:7: want 1", want: Location{File: "/src/a_test.go", Line: 7, Start: 4, End: 15}, ok: true},
+		{line: "/abs/b.go:7:1:text", want: Location{File: "/abs/b.go", Line: 7, Column: 1, End: 13}, ok: true},
+		{line: "x.c:1:2: warning: unused", want: Location{File: "/src/x.c", Line: 1, Column: 2, Severity: "warning", End: 7}, ok: true},
+		{line: `C:\proj\main.go:4:2: oops`, want: Location{File: `C:\proj\main.go`, Line: 4, Column: 2, End: 19}, ok: true},
+		{line: `  File "app/x.py", line 3, in <module>`, want: Location{File: "/src/app/x.py", Line: 3, Start: 8, End: 25}, ok: true},
+		{line: "  --> src/main.rs:3:5", want: Location{File: "/src/src/main.rs", Line: 3, Column: 5, Start: 6, End: 21}, ok: true},
+		{line: "src/app.ts(3,5): error TS2304: Cannot find name", want: Location{File: "/src/src/app.ts", Line: 3, Column: 5, Severity: "error", End: 14}, ok: true},
+		{line: "Command: go build", ok: false},
+		{line: "Dir: /src", ok: false},
+		{line: "note: something: else", ok: false},
+		{line: "", ok: false},
+	}
+	formats := defaultConfig.AllErrorFormats()
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := ParseLocation(formats, []byte(tt.line), "/src")
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAddErrorFormat(t *testing.T) {
+	cfg := defaultConfig
+	assert.Error(t, cfg.AddErrorFormat("nofile", `(?P<line>\d+)`))
+	assert.Error(t, cfg.AddErrorFormat("invalid", `(`))
+	assert.NoError(t, addToConfig(&cfg, "error_format", `custom ^ERR (?P<file>\S+) at (?P<line>\d+)`))
+	loc, ok := ParseLocation(cfg.AllErrorFormats(), []byte("ERR foo.txt at 9"), "/src")
+	assert.True(t, ok)
+	assert.Equal(t, Location{File: "/src/foo.txt", Line: 9, Start: 4, End: 16}, loc)
+	assert.Empty(t, defaultConfig.ErrorFormats)
+}
+
+func TestStepLocation(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestContext()
+	editWindow := &Window{}
+	c.AddWindowInANewColumn(editWindow)
+	output := NewBufferView(c, c.Cfg, &Buffer{File: "*Compilation*", Content: NewPieceTable(nil)})
+	output.Buffer.Reset([]byte("Command: go build\na.go:2:3: first\nnoise\nb.go:4: second\nDone\n"))
+	output.Locations = newLocationList(dir)
+	c.AddDrawable(output)
+	c.locationOutput = output
+	c.BuildWindow.ID = -10
+	c.BuildWindow.DrawableID = output.ID
+	c.ActiveWindowIndex = c.BuildWindow.ID
+
+	opened := func() *BufferView {
+		view, _ := c.GetDrawable(editWindow.DrawableID).(*BufferView)
+		return view
+	}
+
+	NextLocation(c)
+	assert.Equal(t, 1, output.Locations.Current)
+	assert.Equal(t, editWindow.ID, c.ActiveWindowIndex)
+	assert.Equal(t, filepath.Join(dir, "a.go"), opened().Buffer.File)
+	assert.Equal(t, &Position{Line: 2, Column: 2}, opened().MoveToPositionInNextRender)
+
+	NextLocation(c)
+	assert.Equal(t, 3, output.Locations.Current)
+	assert.Equal(t, filepath.Join(dir, "b.go"), opened().Buffer.File)
+
+	NextLocation(c)
+	assert.Equal(t, 3, output.Locations.Current)
+	assert.Equal(t, "No more locations", c.StatusMessage)
+
+	PreviousLocation(c)
+	assert.Equal(t, 1, output.Locations.Current)
+	assert.Equal(t, filepath.Join(dir, "a.go"), opened().Buffer.File)
+	assert.Equal(t, output.Buffer.Lines().LineStart(1), output.Cursor.Point)
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package preditor
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of f read only, mapping stays valid after f is closed.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a mapping returned by mapFile, data must not be used after.
+func unmapFile(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
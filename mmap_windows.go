@@ -0,0 +1,32 @@
+package preditor
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile maps size bytes of f read only, mapping stays valid after f is closed.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READONLY, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	// view keeps the mapping alive.
+	defer syscall.CloseHandle(mapping)
+	addr, err := syscall.MapViewOfFile(mapping, syscall.FILE_MAP_READ, 0, 0, uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+	// addr is memory the Go runtime doesn't manage, it can't be moved or collected and stays valid until
+	// unmapFile, so turning it into a pointer is safe even though vet can't tell.
+	return unsafe.Slice((*byte)(unsafe.Pointer(addr)), size), nil
+}
+
+// unmapFile releases a mapping returned by mapFile, data must not be used after.
+func unmapFile(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return syscall.UnmapViewOfFile(uintptr(unsafe.Pointer(unsafe.SliceData(data))))
+}
@@ -0,0 +1,230 @@
+package preditor
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// occur is state of an occur buffer, it lists lines of source (or of every open file buffer when all is set)
+// that match re.
+type occur struct {
+	query    string
+	re       *regexp.Regexp
+	source   *Buffer
+	all      bool
+	versions map[*Buffer]int // Version of every listed buffer when list was built
+	lines    []occurLine     // by line of occur buffer
+}
+
+// occurLine is where a line of occur buffer comes from, Buffer is nil for headers.
+type occurLine struct {
+	Buffer *Buffer
+	Start  int // offset of the line in Buffer
+	Match  int // offset of first match in the line
+	prefix int // length of line number prefix in occur buffer
+}
+
+// sources returns buffers that are listed, self is the occur buffer which is never its own source as every
+// refresh would change it again. Large file buffers are skipped, matching them would read the whole file.
+func (o *occur) sources(c *Context, self *Buffer) []*Buffer {
+	if !o.all {
+		if o.source == self {
+			return nil
+		}
+		return []*Buffer{o.source}
+	}
+	var bufs []*Buffer
+	for _, buf := range c.Buffers {
+		if buf != self && !buf.Large && buf.File != "" && buf.File[0] != '*' {
+			bufs = append(bufs, buf)
+		}
+	}
+	sort.Slice(bufs, func(i, j int) bool { return bufs[i].File < bufs[j].File })
+	return bufs
+}
+
+func (o *occur) stale(sources []*Buffer) bool {
+	if o.versions == nil || len(sources) != len(o.versions) {
+		return true
+	}
+	for _, buf := range sources {
+		if v, ok := o.versions[buf]; !ok || v != buf.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshOccur lists matching lines again if a source buffer changed since the list was built.
+func (e *BufferView) refreshOccur() {
+	o := e.occur
+	sources := o.sources(e.parent, e.Buffer)
+	if !o.stale(sources) {
+		return
+	}
+	var out bytes.Buffer
+	o.versions = map[*Buffer]int{}
+	o.lines = nil
+	for _, buf := range sources {
+		o.versions[buf] = buf.Version
+		content := buf.Content.Bytes()
+		lines := buf.Lines()
+		var found []occurLine
+		for _, m := range matchPattern(content, o.re) {
+			line := lines.LineForOffset(m[0])
+			if len(found) > 0 && found[len(found)-1].Start == lines.LineStart(line) {
+				continue
+			}
+			found = append(found, occurLine{Buffer: buf, Start: lines.LineStart(line), Match: m[0]})
+		}
+		if o.all && len(found) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "%d lines in %s match %s\n", len(found), buf.File, o.query)
+		o.lines = append(o.lines, occurLine{})
+		width := len(fmt.Sprint(lines.LineCount()))
+		for _, l := range found {
+			line := lines.LineForOffset(l.Start)
+			prefix := fmt.Sprintf("%*d:", width, line+1)
+			l.prefix = len(prefix)
+			out.WriteString(prefix)
+			out.Write(content[l.Start:lines.LineEnd(line)])
+			out.WriteByte('\n')
+			o.lines = append(o.lines, l)
+		}
+	}
+	if o.all && out.Len() == 0 {
+		fmt.Fprintf(&out, "No lines in open buffers match %s\n", o.query)
+		o.lines = append(o.lines, occurLine{})
+	}
+	e.Buffer.Reset(out.Bytes())
+	e.Cursor.Point = min(e.Cursor.Point, e.Buffer.Content.Len())
+	e.Cursor.Mark = min(e.Cursor.Mark, e.Buffer.Content.Len())
+}
+
+// NewOccurBuffer lists lines of source matching re, when source is nil every open file buffer is searched.
+// The list is rebuilt whenever one of them changes, there is only one occur buffer and it's reused.
+func NewOccurBuffer(parent *Context, cfg *Config, query string, re *regexp.Regexp, source *Buffer) *BufferView {
+	var bufferView *BufferView
+	for _, d := range parent.Drawables {
+		if view, ok := d.(*BufferView); ok && view.occur != nil {
+			bufferView = view
+			break
+		}
+	}
+	if bufferView == nil {
+		bufferView = NewBufferViewFromFilename(parent, cfg, "*Occur*")
+	}
+	bufferView.Buffer.Readonly = true
+	bufferView.Locations = newLocationList(parent.getCWD())
+	parent.locationOutput = bufferView
+	if bufferView.occur == nil {
+		bufferView.keymaps.Push(CompileKeymap)
+	}
+	bufferView.occur = &occur{query: query, re: re, source: source, all: source == nil}
+	bufferView.rerun = func() {
+		bufferView.occur.versions = nil
+		bufferView.refreshOccur()
+	}
+	bufferView.Locations.Current = -1
+	bufferView.Cursor.SetBoth(0)
+	bufferView.refreshOccur()
+	return bufferView
+}
+
+// gotoOccurrence shows the source of given line of occur output, cursor is put on the same column if it's
+// inside the line text and on the first match otherwise.
+func (c *Context) gotoOccurrence(output *BufferView, line int) bool {
+	output.refreshOccur()
+	if line < 0 || line >= len(output.occur.lines) || output.occur.lines[line].Buffer == nil {
+		return false
+	}
+	l := output.occur.lines[line]
+	offset := l.Match
+	outputLines := output.Buffer.Lines()
+	if outputLines.LineForOffset(output.Cursor.Point) == line {
+		if col := output.Cursor.Point - outputLines.LineStart(line); col > l.prefix {
+			sourceLines := l.Buffer.Lines()
+			offset = min(l.Start+col-l.prefix, sourceLines.LineEnd(sourceLines.LineForOffset(l.Start)))
+		}
+	}
+	output.Locations.Current = line
+	c.locationOutput = output
+	output.Cursor.SetBoth(outputLines.LineStart(line))
+	output.ScrollIfNeeded()
+
+	win := c.locationTargetWindow(output)
+	view := c.bufferViewFor(l.Buffer)
+	if view == nil {
+		view = NewBufferView(c, c.Cfg, l.Buffer)
+		c.AddDrawable(view)
+	}
+	win.DrawableID = view.ID
+	pos := l.Buffer.Lines().OffsetToPosition(offset)
+	view.MoveToPositionInNextRender = &Position{Line: pos.Line + 1, Column: pos.Column}
+	c.ActiveWindowIndex = win.ID
+	return true
+}
+
+func (c *Context) windowShowing(id int) *Window {
+	for _, col := range c.Windows {
+		for _, win := range col {
+			if win.DrawableID == id {
+				return win
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Context) occurPrompt(text string, source *Buffer, opts *SearchOptions) {
+	keymap := searchPromptKeymap(PromptKeymap, text, opts)
+	var doneHook func(query string, c *Context)
+	doneHook = func(query string, c *Context) {
+		re, err := compileSearchPattern(query, *opts)
+		if err != nil {
+			c.SetPrompt(fmt.Sprintf("%s [%s]", text, opts), nil, doneHook, &keymap, query)
+			c.Prompt.Error = err.Error()
+			return
+		}
+		view := NewOccurBuffer(c, c.Cfg, query, re, source)
+		if c.GetDrawable(view.ID) != Drawable(view) {
+			c.AddDrawable(view)
+		}
+		if c.windowShowing(view.ID) == nil {
+			VSplit(c).DrawableID = view.ID
+		}
+	}
+	c.SetPrompt(fmt.Sprintf("%s [%s]", text, opts), nil, doneHook, &keymap, "")
+}
+
+// isOccurOutput reports if buf is shown by the occur buffer.
+func (c *Context) isOccurOutput(buf *Buffer) bool {
+	for _, d := range c.Drawables {
+		if view, ok := d.(*BufferView); ok && view.occur != nil && view.Buffer == buf {
+			return true
+		}
+	}
+	return false
+}
+
+// Occur asks for a pattern and lists every line of current buffer matching it.
+func Occur(e *BufferView) {
+	if e.parent.isOccurOutput(e.Buffer) {
+		e.parent.ShowMessage("Occur can't list lines of its own output")
+		return
+	}
+	if e.Buffer.Large {
+		e.parent.ShowMessage("Occur doesn't work on large files, use search (Ctrl-s) which streams through the file")
+		return
+	}
+	e.parent.occurPrompt("Occur", e.Buffer, &e.Search.Options)
+}
+
+// OccurAllBuffers asks for a pattern and lists every line of open file buffers matching it, large files are
+// not searched.
+func OccurAllBuffers(e *BufferView) {
+	e.parent.occurPrompt("Occur in buffers", nil, &e.Search.Options)
+}
@@ -0,0 +1,97 @@
+package preditor
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOccur(t *testing.T) {
+	setupDefaults()
+	root := writeTree(t, map[string]string{
+		"a.txt": "foo\nbar\nfoo foo\nbar\nbar\nbar\nbar\nbar\nbar\nbar\n",
+		"b.txt": "bar\nfoo\n",
+		"c.txt": "nothing\n",
+	})
+	c := newTestContext()
+	sourceWindow := &Window{}
+	c.AddWindowInANewColumn(sourceWindow)
+	occurWindow := &Window{}
+	c.AddWindowInANewColumn(occurWindow)
+
+	a := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(filepath.Join(root, "a.txt")))
+	c.AddDrawable(a)
+	sourceWindow.DrawableID = a.ID
+	output := NewOccurBuffer(c, c.Cfg, "foo", regexp.MustCompile("foo"), a.Buffer)
+	c.AddDrawable(output)
+	occurWindow.DrawableID = output.ID
+	c.ActiveWindowIndex = occurWindow.ID
+	assert.Equal(t, "2 lines in "+a.Buffer.File+" match foo\n 1:foo\n 3:foo foo\n", string(output.Buffer.Content.Bytes()))
+
+	a.AddBytesAtIndex([]byte("foo\n"), a.Buffer.Lines().LineStart(1), true)
+	output.refreshOccur()
+	assert.Equal(t, "3 lines in "+a.Buffer.File+" match foo\n 1:foo\n 2:foo\n 4:foo foo\n", string(output.Buffer.Content.Bytes()))
+
+	output.Cursor.SetBoth(output.Buffer.Lines().LineStart(3) + len(" 4:foo f"))
+	BufferOpenLocationInCurrentLine(c)
+	assert.Equal(t, sourceWindow.ID, c.ActiveWindowIndex)
+	assert.Equal(t, a.ID, sourceWindow.DrawableID)
+	assert.Equal(t, &Position{Line: 4, Column: 5}, a.MoveToPositionInNextRender)
+
+	NextLocation(c)
+	assert.Equal(t, "No more locations", c.StatusMessage)
+	PreviousLocation(c)
+	assert.Equal(t, 2, output.Locations.Current)
+	assert.Equal(t, &Position{Line: 2, Column: 0}, a.MoveToPositionInNextRender)
+
+	c.OpenFileAsBuffer(filepath.Join(root, "b.txt"))
+	c.OpenFileAsBuffer(filepath.Join(root, "c.txt"))
+	all := NewOccurBuffer(c, c.Cfg, "fo+", regexp.MustCompile("fo+"), nil)
+	assert.Same(t, output, all)
+	assert.Equal(t, "3 lines in "+a.Buffer.File+" match fo+\n 1:foo\n 2:foo\n 4:foo foo\n"+
+		"1 lines in "+filepath.Join(root, "b.txt")+" match fo+\n2:foo\n", string(all.Buffer.Content.Bytes()))
+
+	assert.True(t, c.GotoLocation(all, 5))
+	b, _ := c.GetDrawable(sourceWindow.DrawableID).(*BufferView)
+	assert.Equal(t, filepath.Join(root, "b.txt"), b.Buffer.File)
+	assert.Equal(t, &Position{Line: 2, Column: 0}, b.MoveToPositionInNextRender)
+}
+
+func TestOccurOfOccurBuffer(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	a := NewBufferView(c, c.Cfg, &Buffer{File: "a.txt", Content: NewPieceTable([]byte("foo\nbar\n"))})
+	c.AddDrawable(a)
+	output := NewOccurBuffer(c, c.Cfg, "foo", regexp.MustCompile("foo"), a.Buffer)
+	c.AddDrawable(output)
+
+	Occur(output)
+	assert.Equal(t, "Occur can't list lines of its own output", c.StatusMessage)
+	assert.False(t, c.Prompt.IsActive)
+
+	NewOccurBuffer(c, c.Cfg, "foo", regexp.MustCompile("foo"), output.Buffer)
+	content := string(output.Buffer.Content.Bytes())
+	for i := 0; i < 5; i++ {
+		output.refreshOccur()
+	}
+	assert.Equal(t, content, string(output.Buffer.Content.Bytes()))
+}
+
+func TestOccurSkipsLargeFiles(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	a := NewBufferView(c, c.Cfg, &Buffer{File: "a.txt", Content: NewPieceTable([]byte("foo\n"))})
+	c.AddDrawable(a)
+	large := NewBufferView(c, c.Cfg, &Buffer{File: "big.log", Large: true, Readonly: true, Content: NewMappedText([]byte("foo\n"))})
+	c.AddDrawable(large)
+	c.Buffers = map[string]*Buffer{a.Buffer.File: a.Buffer, large.Buffer.File: large.Buffer}
+
+	Occur(large)
+	assert.Equal(t, "Occur doesn't work on large files, use search (Ctrl-s) which streams through the file", c.StatusMessage)
+	assert.False(t, c.Prompt.IsActive)
+
+	output := NewOccurBuffer(c, c.Cfg, "foo", regexp.MustCompile("foo"), nil)
+	assert.Equal(t, "1 lines in a.txt match foo\n1:foo\n", string(output.Buffer.Content.Bytes()))
+}
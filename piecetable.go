@@ -0,0 +1,241 @@
+package preditor
+
+// TextStorage is what a Buffer keeps its text in. Slices returned by Slice, Chunk and Bytes
+// are read only views and should be cloned before being mutated or kept across edits.
+type TextStorage interface {
+	Len() int
+	At(idx int) byte
+	Slice(start int, end int) []byte
+	Chunk(idx int) []byte
+	Bytes() []byte
+	Insert(idx int, data []byte)
+	Delete(start int, end int)
+	Reset(data []byte)
+}
+
+const (
+	pieceSource_Original = iota
+	pieceSource_Add
+)
+
+type piece struct {
+	source int
+	start  int
+	length int
+}
+
+// PieceTable stores text as a list of pieces pointing into the original content or an append-only
+// add buffer, so edits cost O(number of pieces) instead of moving the whole tail of the file.
+type PieceTable struct {
+	original []byte
+	add      []byte
+	pieces   []piece
+	length   int
+
+	flat []byte
+
+	// cache of last looked up piece, makes sequential access (At, Chunk) cheap.
+	lastPieceIdx   int
+	lastPieceStart int
+}
+
+func NewPieceTable(content []byte) *PieceTable {
+	pt := &PieceTable{}
+	pt.Reset(content)
+	return pt
+}
+
+func (pt *PieceTable) Reset(content []byte) {
+	pt.original = content
+	pt.add = nil
+	pt.pieces = nil
+	pt.length = len(content)
+	pt.flat = content
+	pt.lastPieceIdx = 0
+	pt.lastPieceStart = 0
+	if len(content) > 0 {
+		pt.pieces = []piece{{source: pieceSource_Original, start: 0, length: len(content)}}
+	}
+}
+
+func (pt *PieceTable) Len() int {
+	return pt.length
+}
+
+func (pt *PieceTable) pieceBytes(p piece) []byte {
+	if p.source == pieceSource_Original {
+		return pt.original[p.start : p.start+p.length]
+	}
+	return pt.add[p.start : p.start+p.length]
+}
+
+// findPiece returns index of the piece containing idx and offset of that piece start in the text.
+// for idx == Len() it returns len(pieces) and Len().
+func (pt *PieceTable) findPiece(idx int) (int, int) {
+	i, start := 0, 0
+	if pt.lastPieceIdx < len(pt.pieces) && pt.lastPieceStart <= idx {
+		i, start = pt.lastPieceIdx, pt.lastPieceStart
+	}
+	for ; i < len(pt.pieces); i++ {
+		if idx < start+pt.pieces[i].length {
+			pt.lastPieceIdx = i
+			pt.lastPieceStart = start
+			return i, start
+		}
+		start += pt.pieces[i].length
+	}
+
+	return len(pt.pieces), start
+}
+
+func (pt *PieceTable) At(idx int) byte {
+	i, start := pt.findPiece(idx)
+	if i >= len(pt.pieces) {
+		panic("PieceTable.At: index out of range")
+	}
+	return pt.pieceBytes(pt.pieces[i])[idx-start]
+}
+
+func (pt *PieceTable) Chunk(idx int) []byte {
+	if idx < 0 || idx >= pt.length {
+		return nil
+	}
+	i, start := pt.findPiece(idx)
+	return pt.pieceBytes(pt.pieces[i])[idx-start:]
+}
+
+func (pt *PieceTable) Slice(start int, end int) []byte {
+	if start < 0 {
+		start = 0
+	}
+	if end > pt.length {
+		end = pt.length
+	}
+	if start >= end {
+		return nil
+	}
+	if pt.flat != nil {
+		return pt.flat[start:end]
+	}
+	i, pieceStart := pt.findPiece(start)
+	first := pt.pieceBytes(pt.pieces[i])[start-pieceStart:]
+	if len(first) >= end-start {
+		return first[:end-start]
+	}
+	out := make([]byte, 0, end-start)
+	out = append(out, first...)
+	for i++; i < len(pt.pieces) && len(out) < end-start; i++ {
+		bs := pt.pieceBytes(pt.pieces[i])
+		if rem := end - start - len(out); len(bs) > rem {
+			bs = bs[:rem]
+		}
+		out = append(out, bs...)
+	}
+
+	return out
+}
+
+func (pt *PieceTable) Bytes() []byte {
+	if pt.flat != nil || pt.length == 0 {
+		return pt.flat
+	}
+	flat := make([]byte, 0, pt.length)
+	for _, p := range pt.pieces {
+		flat = append(flat, pt.pieceBytes(p)...)
+	}
+	pt.flat = flat
+	return flat
+}
+
+func (pt *PieceTable) invalidate() {
+	pt.flat = nil
+	pt.lastPieceIdx = 0
+	pt.lastPieceStart = 0
+}
+
+func (pt *PieceTable) Insert(idx int, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > pt.length {
+		idx = pt.length
+	}
+	i, start := pt.findPiece(idx)
+	pt.invalidate()
+
+	addStart := len(pt.add)
+	pt.add = append(pt.add, data...)
+	pt.length += len(data)
+	newPiece := piece{source: pieceSource_Add, start: addStart, length: len(data)}
+
+	// typing at the end of the last insert, just grow that piece
+	if idx == start && i > 0 {
+		prev := &pt.pieces[i-1]
+		if prev.source == pieceSource_Add && prev.start+prev.length == addStart {
+			prev.length += len(data)
+			return
+		}
+	}
+
+	if i == len(pt.pieces) || idx == start {
+		pt.pieces = append(pt.pieces, piece{})
+		copy(pt.pieces[i+1:], pt.pieces[i:])
+		pt.pieces[i] = newPiece
+		return
+	}
+
+	// split piece i into left, new, right
+	p := pt.pieces[i]
+	left := piece{source: p.source, start: p.start, length: idx - start}
+	right := piece{source: p.source, start: p.start + left.length, length: p.length - left.length}
+	pt.pieces = append(pt.pieces, piece{}, piece{})
+	copy(pt.pieces[i+3:], pt.pieces[i+1:])
+	pt.pieces[i] = left
+	pt.pieces[i+1] = newPiece
+	pt.pieces[i+2] = right
+}
+
+func (pt *PieceTable) Delete(start int, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > pt.length {
+		end = pt.length
+	}
+	if start >= end {
+		return
+	}
+	first, firstStart := pt.findPiece(start)
+	pt.invalidate()
+	pt.length -= end - start
+
+	var replacement []piece
+	last := first
+	pieceStart := firstStart
+	for ; last < len(pt.pieces); last++ {
+		p := pt.pieces[last]
+		pieceEnd := pieceStart + p.length
+		if start > pieceStart {
+			replacement = append(replacement, piece{source: p.source, start: p.start, length: start - pieceStart})
+		}
+		if end < pieceEnd {
+			cut := end - pieceStart
+			replacement = append(replacement, piece{source: p.source, start: p.start + cut, length: p.length - cut})
+			break
+		}
+		pieceStart = pieceEnd
+		if pieceEnd == end {
+			break
+		}
+	}
+
+	tail := pt.pieces[last+1:]
+	pt.pieces = append(pt.pieces[:first], append(replacement, tail...)...)
+}
+
+func (pt *PieceTable) String() string {
+	return string(pt.Bytes())
+}
@@ -0,0 +1,109 @@
+package preditor
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPieceTable(t *testing.T) {
+	pt := NewPieceTable([]byte("hello world"))
+	pt.Insert(5, []byte(","))
+	pt.Insert(pt.Len(), []byte("!"))
+	pt.Insert(0, []byte(">> "))
+	assert.Equal(t, ">> hello, world!", string(pt.Bytes()))
+	assert.Equal(t, byte('h'), pt.At(3))
+	assert.Equal(t, "hello, w", string(pt.Slice(3, 11)))
+
+	pt.Delete(0, 3)
+	pt.Delete(5, 6)
+	assert.Equal(t, "hello world!", string(pt.Bytes()))
+
+	pt.Delete(2, 9)
+	assert.Equal(t, "held!", string(pt.Bytes()))
+
+	pt.Reset(nil)
+	assert.Equal(t, 0, pt.Len())
+	pt.Insert(0, []byte("a"))
+	pt.Insert(1, []byte("b"))
+	assert.Equal(t, "ab", string(pt.Bytes()))
+}
+
+func TestPieceTableRandomEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	expected := []byte("package main\n\nfunc main() {}\n")
+	pt := NewPieceTable(bytes.Clone(expected))
+	for i := 0; i < 2000; i++ {
+		idx := r.Intn(len(expected) + 1)
+		if r.Intn(3) == 0 && len(expected) > 0 {
+			end := idx + r.Intn(8)
+			if end > len(expected) {
+				end = len(expected)
+			}
+			pt.Delete(idx, end)
+			expected = append(expected[:idx:idx], expected[end:]...)
+		} else {
+			data := []byte(fmt.Sprint(i))
+			pt.Insert(idx, data)
+			expected = append(expected[:idx:idx], append(data, expected[idx:]...)...)
+		}
+		if i%100 == 0 {
+			assert.Equal(t, string(expected), string(pt.Bytes()))
+		}
+	}
+	assert.Equal(t, string(expected), string(pt.Bytes()))
+	var chunked []byte
+	for i := 0; i < pt.Len(); {
+		chunk := pt.Chunk(i)
+		chunked = append(chunked, chunk...)
+		i += len(chunk)
+	}
+	assert.Equal(t, string(expected), string(chunked))
+}
+
+var benchmarkFileSizes = []int{1 << 10, 1 << 20, 16 << 20}
+
+func BenchmarkPieceTableInsert(b *testing.B) {
+	for _, size := range benchmarkFileSizes {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			pt := NewPieceTable(bytes.Repeat([]byte("a"), size))
+			idx := size / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pt.Insert(idx, []byte{'x'})
+				idx++
+			}
+		})
+	}
+}
+
+func BenchmarkPieceTableDelete(b *testing.B) {
+	for _, size := range benchmarkFileSizes {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			pt := NewPieceTable(bytes.Repeat([]byte("a"), size+b.N))
+			idx := size / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pt.Delete(idx, idx+1)
+			}
+		})
+	}
+}
+
+// old []byte based storage, kept to compare against.
+func BenchmarkFlatBytesInsert(b *testing.B) {
+	for _, size := range benchmarkFileSizes {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			content := bytes.Repeat([]byte("a"), size)
+			idx := size / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				content = append(content[:idx], append([]byte{'x'}, content[idx:]...)...)
+				idx++
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package preditor
 
 import (
-	"bufio"
 	"bytes"
 	_ "embed"
 	"errors"
@@ -9,14 +8,19 @@ import (
 	"fmt"
 	// "image"
 	"image/color"
+	"io"
 	"math/rand"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/flopp/go-findfont"
 
@@ -104,6 +108,49 @@ type Prompt struct {
 	DoneHook   func(userInput string, c *Context)
 	ChangeHook func(userInput string, c *Context)
 	NoRender   bool
+	Error      string
+}
+
+// PromptHistory keeps previous inputs of a prompt, newest last. Prev and Next walk it starting after the
+// newest entry.
+type PromptHistory[T comparable] struct {
+	Entries []T
+	pos     int
+}
+
+// Add appends entry, an older equal entry is removed.
+func (h *PromptHistory[T]) Add(entry T) {
+	for i, e := range h.Entries {
+		if e == entry {
+			h.Entries = append(h.Entries[:i], h.Entries[i+1:]...)
+			break
+		}
+	}
+	h.Entries = append(h.Entries, entry)
+	h.Rewind()
+}
+
+// Rewind moves position back after the newest entry, it's called when prompt is opened.
+func (h *PromptHistory[T]) Rewind() {
+	h.pos = len(h.Entries)
+}
+
+func (h *PromptHistory[T]) Prev() (T, bool) {
+	if h.pos == 0 {
+		return *new(T), false
+	}
+	h.pos--
+	return h.Entries[h.pos], true
+}
+
+// Next returns the entry after current one, false means we moved past the newest entry.
+func (h *PromptHistory[T]) Next() (T, bool) {
+	if h.pos >= len(h.Entries)-1 {
+		h.pos = len(h.Entries)
+		return *new(T), false
+	}
+	h.pos++
+	return h.Entries[h.pos], true
 }
 
 const (
@@ -135,12 +182,35 @@ type Context struct {
 	FontData          []byte
 	Font              rl.Font
 	FontSize          int32
+	FontCodepoints    []rune
+	fontCodepointSet  map[rune]bool
+	missingCodepoints map[rune]bool
 	OSWindowHeight    float64
 	OSWindowWidth     float64
 	Windows           [][]*Window
 	BuildWindow       BuildWindow
 	Prompt            Prompt
 	ActiveWindowIndex int
+	InputChar         rune
+	StatusMessage     string
+	StatusMessageTime time.Time
+
+	locationOutput *BufferView // most recent compilation or grep buffer
+	GrepHistory    PromptHistory[GrepQuery]
+	Diagnostics    []*Diagnostic
+	Projects       map[string]*Project
+	SaveHooks      []func(*BufferView) // called after a buffer is written by Write
+
+	fileWatchBatches chan []watchedFile
+	fileChanges      chan watchedFile
+	lastFileWatch    time.Time
+
+	JournalDir      string
+	lastJournal     time.Time
+	pendingJournals []*Journal
+
+	postedMu sync.Mutex
+	posted   []func(c *Context)
 }
 
 var GlobalKeymap = Keymap{}
@@ -150,6 +220,15 @@ func (c *Context) GetBufferByFilename(filename string) *Buffer {
 }
 
 func (c *Context) OpenFileAsBuffer(filename string) *Buffer {
+	if info, err := os.Stat(filename); err == nil && c.Cfg != nil && c.Cfg.LargeFileThreshold > 0 && info.Size() >= c.Cfg.LargeFileThreshold {
+		buf, err := c.openLargeFile(filename, info.Size())
+		if err == nil {
+			c.Buffers[filename] = buf
+			c.attachDiagnostics(buf)
+			return buf
+		}
+		fmt.Println("ERROR: cannot map file", err.Error())
+	}
 	content, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Println("ERROR: cannot read file", err.Error())
@@ -159,6 +238,9 @@ func (c *Context) OpenFileAsBuffer(filename string) *Buffer {
 		File:  filename,
 		State: State_Clean,
 	}
+	if info, err := os.Stat(filename); err == nil {
+		buf.DiskState = newFileState(info, content)
+	}
 
 	//replace CRLF with LF
 	if bytes.Index(content, []byte("\r\n")) != -1 {
@@ -171,8 +253,9 @@ func (c *Context) OpenFileAsBuffer(filename string) *Buffer {
 		buf.fileType = fileType
 		buf.needParsing = true
 	}
-	buf.Content = content
+	buf.Content = NewPieceTable(content)
 	c.Buffers[filename] = &buf
+	c.attachDiagnostics(&buf)
 
 	return &buf
 }
@@ -202,7 +285,7 @@ func (c *Context) ResetPrompt() {
 	c.Prompt.UserInput = ""
 	c.Prompt.DoneHook = nil
 	c.Prompt.ChangeHook = nil
-
+	c.Prompt.Error = ""
 }
 func (c *Context) SetPrompt(text string,
 	changeHook func(userInput string, c *Context),
@@ -212,6 +295,7 @@ func (c *Context) SetPrompt(text string,
 	c.Prompt.DoneHook = doneHook
 	c.Prompt.UserInput = defaultValue
 	c.Prompt.ChangeHook = changeHook
+	c.Prompt.Error = ""
 	if keymap != nil {
 		c.Prompt.Keymap = *keymap
 	} else {
@@ -240,9 +324,9 @@ func (c *Context) ActiveDrawableID() int {
 	return -1
 }
 
-var charSizeCache = map[byte]rl.Vector2{}
+var charSizeCache = map[rune]rl.Vector2{}
 
-func measureTextSize(font rl.Font, s byte, size int32, spacing float32) rl.Vector2 {
+func measureTextSize(font rl.Font, s rune, size int32, spacing float32) rl.Vector2 {
 	if charSize, exists := charSizeCache[s]; exists {
 		return charSize
 	}
@@ -252,7 +336,35 @@ func measureTextSize(font rl.Font, s byte, size int32, spacing float32) rl.Vecto
 }
 
 func (c *Context) WriteMessage(msg string) {
-	c.GetDrawable(c.MessageDrawableID).(*BufferView).Buffer.Content = append(c.GetDrawable(c.MessageDrawableID).(*BufferView).Buffer.Content, []byte(fmt.Sprintln(msg))...)
+	c.GetDrawable(c.MessageDrawableID).(*BufferView).Buffer.Append([]byte(fmt.Sprintln(msg)))
+}
+
+// Post queues f to run on the main loop, goroutines must use it for anything that touches editor state.
+func (c *Context) Post(f func(c *Context)) {
+	c.postedMu.Lock()
+	c.posted = append(c.posted, f)
+	c.postedMu.Unlock()
+}
+
+// RunPosted runs everything queued with Post, main loop calls it every frame.
+func (c *Context) RunPosted() {
+	c.postedMu.Lock()
+	posted := c.posted
+	c.posted = nil
+	c.postedMu.Unlock()
+	for _, f := range posted {
+		f(c)
+	}
+}
+
+// how long a message from ShowMessage stays in the statusbar.
+const statusMessageTimeout = 5 * time.Second
+
+// ShowMessage writes msg to *Messages* and shows it in the statusbar of active window.
+func (c *Context) ShowMessage(msg string) {
+	c.WriteMessage(msg)
+	c.StatusMessage = msg
+	c.StatusMessageTime = time.Now()
 }
 
 func (c *Context) getCWD() string {
@@ -389,21 +501,78 @@ func (c *Context) LoadFont(name string, size int32) error {
 	}
 
 	c.FontSize = size
-	c.Font = rl.LoadFontFromMemory(".ttf", c.FontData, int32(len(c.FontData)), c.FontSize, nil, 0)
+	if c.FontCodepoints == nil {
+		c.FontCodepoints = defaultCodepoints()
+	}
+	c.reloadFont()
 	return nil
 }
 
+// defaultCodepoints are always in the font atlas, anything else is added when it shows up on screen.
+func defaultCodepoints() []rune {
+	var codepoints []rune
+	for _, r := range [][2]rune{
+		{0x20, 0x7E},     // ascii
+		{0xA0, 0x24F},    // latin-1 and latin extended
+		{0x370, 0x4FF},   // greek and cyrillic
+		{0x2000, 0x206F}, // general punctuation
+		{0x2190, 0x22FF}, // arrows and math operators
+		{0x2500, 0x25FF}, // box drawing and geometric shapes
+	} {
+		for cp := r[0]; cp <= r[1]; cp++ {
+			codepoints = append(codepoints, cp)
+		}
+	}
+	return codepoints
+}
+
+func (c *Context) reloadFont() {
+	c.fontCodepointSet = map[rune]bool{}
+	for _, cp := range c.FontCodepoints {
+		c.fontCodepointSet[cp] = true
+	}
+	if rl.IsFontReady(c.Font) {
+		rl.UnloadFont(c.Font)
+	}
+	c.Font = rl.LoadFontFromMemory(".ttf", c.FontData, int32(len(c.FontData)), c.FontSize, &c.FontCodepoints[0], int32(len(c.FontCodepoints)))
+	charSizeCache = map[rune]rl.Vector2{}
+}
+
+// RequireCodepoints marks runes in bs that are not in font atlas, they get loaded before next frame.
+func (c *Context) RequireCodepoints(bs []byte) {
+	for len(bs) > 0 {
+		r, size := utf8.DecodeRune(bs)
+		bs = bs[size:]
+		if r < utf8.RuneSelf || r == utf8.RuneError || c.fontCodepointSet[r] {
+			continue
+		}
+		if c.missingCodepoints == nil {
+			c.missingCodepoints = map[rune]bool{}
+		}
+		c.missingCodepoints[r] = true
+	}
+}
+
+func (c *Context) loadMissingCodepoints() {
+	if len(c.missingCodepoints) == 0 || c.FontData == nil {
+		return
+	}
+	for r := range c.missingCodepoints {
+		c.FontCodepoints = append(c.FontCodepoints, r)
+	}
+	c.missingCodepoints = nil
+	sort.Slice(c.FontCodepoints, func(i, j int) bool { return c.FontCodepoints[i] < c.FontCodepoints[j] })
+	c.reloadFont()
+}
+
 func (c *Context) IncreaseFontSize(n int) {
 	c.FontSize += int32(n)
-	c.Font = rl.LoadFontFromMemory(".ttf", c.FontData, int32(len(c.FontData)), c.FontSize, nil, 0)
-	charSizeCache = map[byte]rl.Vector2{}
+	c.reloadFont()
 }
 
 func (c *Context) DecreaseFontSize(n int) {
 	c.FontSize -= int32(n)
-	c.Font = rl.LoadFontFromMemory(".ttf", c.FontData, int32(len(c.FontData)), c.FontSize, nil, 0)
-	charSizeCache = map[byte]rl.Vector2{}
-
+	c.reloadFont()
 }
 
 type Command func(*Context)
@@ -476,27 +645,53 @@ func parseHexColor(v string) (out color.RGBA, err error) {
 	return
 }
 
+// TextInputKey is bound to the command that handles typed text, Context.InputChar holds the character.
+var TextInputKey = Key{K: "<text-input>"}
+
+func (c *Context) lookupCommand(key Key) Command {
+	keymaps := []Keymap{c.GlobalKeymap}
+	if c.ActiveDrawable() != nil {
+		keymaps = append(keymaps, c.ActiveDrawable().Keymaps()...)
+	}
+	if c.Prompt.IsActive {
+		keymaps = append(keymaps, c.Prompt.Keymap)
+	}
+	for i := len(keymaps) - 1; i >= 0; i-- {
+		if cmd := keymaps[i][key]; cmd != nil {
+			return cmd
+		}
+	}
+	return nil
+}
+
 func (c *Context) HandleKeyEvents() {
 	defer handlePanicAndWriteMessage(c)
 	key := getKey()
+	var handled bool
 	if !key.IsEmpty() {
-
-		keymaps := []Keymap{c.GlobalKeymap}
-		if c.ActiveDrawable() != nil {
-			keymaps = append(keymaps, c.ActiveDrawable().Keymaps()...)
-		}
-		if c.Prompt.IsActive {
-			keymaps = append(keymaps, c.Prompt.Keymap)
+		if cmd := c.lookupCommand(key); cmd != nil {
+			cmd(c)
+			handled = true
 		}
-		for i := len(keymaps) - 1; i >= 0; i-- {
-			cmd := keymaps[i][key]
-			if cmd != nil {
-				cmd(c)
-				break
-			}
+	}
+
+	// characters produced by a key that ran a command are dropped, otherwise they are typed.
+	for char := rl.GetCharPressed(); char != 0; char = rl.GetCharPressed() {
+		if handled {
+			continue
 		}
+		c.HandleTextInput(char)
 	}
+}
 
+func (c *Context) HandleTextInput(char rune) {
+	if !unicode.IsPrint(char) {
+		return
+	}
+	if cmd := c.lookupCommand(TextInputKey); cmd != nil {
+		c.InputChar = char
+		cmd(c)
+	}
 }
 
 func (c *Context) GetWindow(id int) *Window {
@@ -575,7 +770,12 @@ func (c *Context) Render() {
 
 	if c.Prompt.IsActive && !c.Prompt.NoRender {
 		rl.DrawRectangle(0, int32(height), int32(c.OSWindowWidth), int32(charsize.Y), c.Cfg.CurrentThemeColors().Prompts.ToColorRGBA())
-		rl.DrawTextEx(c.Font, fmt.Sprintf("%s: %s", c.Prompt.Text, c.Prompt.UserInput), rl.Vector2{
+		promptText := fmt.Sprintf("%s: %s", c.Prompt.Text, c.Prompt.UserInput)
+		if c.Prompt.Error != "" {
+			promptText += "  (" + c.Prompt.Error + ")"
+		}
+		c.RequireCodepoints([]byte(promptText))
+		rl.DrawTextEx(c.Font, promptText, rl.Vector2{
 			X: 0,
 			Y: float32(height),
 		}, float32(c.FontSize), 0, rl.White)
@@ -983,6 +1183,9 @@ func New() (*Context, error) {
 	}
 
 	setupDefaults()
+	p.startFileWatcher()
+	p.SaveHooks = append(p.SaveHooks, CompileOnSave)
+	p.JournalDir = defaultJournalDir()
 	err = p.LoadFont(cfg.FontName, int32(cfg.FontSize))
 	if err != nil {
 		return nil, err
@@ -990,7 +1193,7 @@ func New() (*Context, error) {
 	scratch := NewBufferViewFromFilename(p, p.Cfg, "*Scratch*")
 	message := NewBufferViewFromFilename(p, p.Cfg, "*Messages*")
 	message.Buffer.Readonly = true
-	message.Buffer.Content = append(message.Buffer.Content, []byte(fmt.Sprintf("Loaded Configuration from '%s':\n%s\n", configPath, cfg))...)
+	message.Buffer.Append([]byte(fmt.Sprintf("Loaded Configuration from '%s':\n%s\n", configPath, cfg)))
 
 	p.AddDrawable(scratch)
 	p.AddDrawable(message)
@@ -1021,17 +1224,7 @@ func New() (*Context, error) {
 			p.AddDrawable(tb)
 			p.MarkDrawableAsActive(tb.ID)
 			tb.Buffer.Readonly = true
-			go func() {
-				r := bufio.NewReader(os.Stdin)
-				for {
-					b, err := r.ReadByte()
-					if err != nil {
-						p.WriteMessage(err.Error())
-						break
-					}
-					tb.Buffer.Content = append(tb.Buffer.Content, b)
-				}
-			}()
+			go p.ReadIntoBuffer(os.Stdin, tb.Buffer)
 		} else {
 			err = SwitchOrOpenFileInCurrentWindow(p, cfg, filename, nil)
 			if err != nil {
@@ -1039,10 +1232,33 @@ func New() (*Context, error) {
 			}
 		}
 	}
+	p.RecoverJournals()
 
 	return p, nil
 }
 
+// ReadIntoBuffer appends everything read from r to buf until EOF, it blocks so should run in a goroutine.
+func (c *Context) ReadIntoBuffer(r io.Reader, buf *Buffer) {
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			data := bytes.Clone(chunk[:n])
+			c.Post(func(c *Context) {
+				buf.Append(data)
+			})
+		}
+		if err != nil {
+			if err != io.EOF {
+				c.Post(func(c *Context) {
+					c.WriteMessage(err.Error())
+				})
+			}
+			return
+		}
+	}
+}
+
 func Exit(c *Context) {
 	rl.CloseWindow()
 }
@@ -1059,6 +1275,7 @@ func (c *Context) StartMainLoop() {
 			}
 
 			fmt.Printf("%v\n%s\n", r, string(debug.Stack()))
+			c.JournalDirtyBuffers(true)
 		}
 	}()
 	//TODO: Check for drag and dropped files rl.IsFileDropped()
@@ -1074,8 +1291,14 @@ func (c *Context) StartMainLoop() {
 		c.HandleWindowResize()
 		c.HandleMouseEvents()
 		c.HandleKeyEvents()
+		c.RunPosted()
+		c.RunScheduledBuilds()
+		c.CheckExternalChanges()
+		c.JournalDirtyBuffers(false)
+		c.loadMissingCodepoints()
 		c.Render()
 	}
+	c.RemoveJournals()
 }
 
 func MakeCommand[T Drawable](f func(t T)) Command {
@@ -1117,6 +1340,18 @@ func (c *Context) OpenThemesList() {
 	c.MarkDrawableAsActive(ofb.ID)
 }
 
+func (c *Context) OpenDiagnosticsList() {
+	ofb := NewDiagnosticsList(c, c.Cfg)
+	c.AddDrawable(ofb)
+	c.MarkDrawableAsActive(ofb.ID)
+}
+
+func (c *Context) OpenUndoTreeList(bufferView *BufferView) {
+	ofb := NewUndoTreeList(c, c.Cfg, bufferView)
+	c.AddDrawable(ofb)
+	c.MarkDrawableAsActive(ofb.ID)
+}
+
 func SwitchOrOpenFileInWindow(parent *Context, cfg *Config, filename string, startingPos *Position, window *Window) error {
 	bufferView := NewBufferViewFromFilename(parent, cfg, filename)
 	parent.AddDrawable(bufferView)
@@ -1200,13 +1435,14 @@ func Compile(c *Context) {
 
 }
 
-func (c *Context) OpenGrepBufferInSensibleSplit(command string) error {
+func (c *Context) OpenGrepBufferInSensibleSplit(query GrepQuery) error {
+	dir := c.GrepDir(query.Scope)
 	var window *Window
 	for _, col := range c.Windows {
 		for _, win := range col {
 			if buf := c.GetDrawable(win.DrawableID); buf != nil {
 				if b, is := buf.(*BufferView); is {
-					if b.Buffer.File == "*Grep*" {
+					if strings.HasPrefix(b.Buffer.File, "*Grep*") {
 						window = win
 					}
 				}
@@ -1217,11 +1453,13 @@ func (c *Context) OpenGrepBufferInSensibleSplit(command string) error {
 	if window == nil {
 		window = VSplit(c)
 	}
-	cb, err := NewGrepBuffer(c, c.Cfg, command)
+	cb, err := NewGrepBufferInDir(c, c.Cfg, dir, query)
 	if err != nil {
 		return err
 	}
-	c.AddDrawable(cb)
+	if c.GetDrawable(cb.ID) != Drawable(cb) {
+		c.AddDrawable(cb)
+	}
 	window.DrawableID = cb.ID
 
 	return nil
@@ -1233,7 +1471,9 @@ func (c *Context) OpenCompilationBufferInBuildWindow(command string) error {
 		return err
 	}
 
-	c.AddDrawable(cb)
+	if c.GetDrawable(cb.ID) != Drawable(cb) {
+		c.AddDrawable(cb)
+	}
 
 	c.BuildWindow.DrawableID = cb.ID
 
@@ -0,0 +1,121 @@
+package preditor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleTextInput(t *testing.T) {
+	setupDefaults()
+	c := &Context{GlobalKeymap: Keymap{}}
+	c.SetPrompt("Test", nil, nil, nil, "")
+	for _, r := range "ñ世🙂" {
+		c.HandleTextInput(r)
+	}
+	c.HandleTextInput('\x1b')
+	assert.Equal(t, "ñ世🙂", c.Prompt.UserInput)
+
+	c.lookupCommand(Key{K: "<backspace>"})(c)
+	assert.Equal(t, "ñ世", c.Prompt.UserInput)
+}
+
+// runUntil runs posted work like the main loop does until cond is true.
+func runUntil(t *testing.T, c *Context, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for posted work")
+		}
+		c.RunPosted()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReadIntoBuffer(t *testing.T) {
+	c := newTestContext()
+	buf := &Buffer{Content: NewPieceTable(nil)}
+	r, w := io.Pipe()
+	go c.ReadIntoBuffer(r, buf)
+	go func() {
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+		}
+		w.Close()
+	}()
+	runUntil(t, c, func() bool {
+		return buf.Lines().LineCount() == 101
+	})
+	assert.True(t, strings.HasPrefix(string(buf.Content.Bytes()), "line 0\nline 1\n"))
+}
+
+func TestCompilationBufferOutput(t *testing.T) {
+	c := newTestContext()
+	view, err := NewCompilationBuffer(c, c.Cfg, "go version")
+	assert.NoError(t, err)
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "Finished in")
+	})
+	assert.Contains(t, string(view.Buffer.Content.Bytes()), "go version go")
+}
+
+func TestKillCompilation(t *testing.T) {
+	c := newTestContext()
+	view := NewBufferViewFromFilename(c, c.Cfg, "*Compilation*")
+	assert.NoError(t, view.RunProcess(exec.Command("sh", "-c", "echo started; sleep 30"), nil))
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "started")
+	})
+	KillProcess(view)
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "Killed after")
+	})
+	assert.True(t, view.process.Finished)
+}
+
+func TestKillFunc(t *testing.T) {
+	c := newTestContext()
+	view := NewBufferViewFromFilename(c, c.Cfg, "*Grep*")
+	view.RunFunc(func(ctx context.Context, w io.Writer) error {
+		fmt.Fprintln(w, "started")
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "started")
+	})
+	KillProcess(view)
+	runUntil(t, c, func() bool {
+		return strings.Contains(string(view.Buffer.Content.Bytes()), "Killed after")
+	})
+}
+
+func TestRerunCompilationCancelsPrevious(t *testing.T) {
+	c := newTestContext()
+	view, err := NewCompilationBuffer(c, c.Cfg, "sleep 30")
+	assert.NoError(t, err)
+	first := view.process
+	assert.NoError(t, view.RunProcess(exec.Command("echo", "second"), nil))
+	runUntil(t, c, func() bool {
+		return first.Finished && strings.Contains(string(view.Buffer.Content.Bytes()), "Finished in")
+	})
+	assert.NotContains(t, string(view.Buffer.Content.Bytes()), "Killed")
+}
+
+func TestSearchResultsArePosted(t *testing.T) {
+	c := newTestContext()
+	view := newTestBufferView("foo bar foo")
+	view.keymaps = NewUnboundedStack[Keymap](5)
+	view.searchFor("fo", c)
+	view.searchFor("foo", c)
+	runUntil(t, c, func() bool {
+		return len(view.Search.SearchMatches) == 2
+	})
+	assert.Equal(t, [][]int{{0, 2}, {8, 10}}, view.Search.SearchMatches)
+}
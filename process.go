@@ -0,0 +1,175 @@
+package preditor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Process is a command, or a function when Cmd is nil, whose output is streamed into a buffer.
+type Process struct {
+	Cmd      *exec.Cmd
+	Started  time.Time
+	Finished bool
+
+	stopped string             // set when user interrupted or killed the process
+	cancel  context.CancelFunc // stops a function
+}
+
+func (p *Process) Interrupt() error {
+	if p.Finished {
+		return nil
+	}
+	p.stopped = "Interrupted"
+	if p.Cmd == nil {
+		p.cancel()
+		return nil
+	}
+	return interruptProcessGroup(p.Cmd)
+}
+
+func (p *Process) Kill() error {
+	if p.Finished {
+		return nil
+	}
+	p.stopped = "Killed"
+	if p.Cmd == nil {
+		p.cancel()
+		return nil
+	}
+	return killProcessGroup(p.Cmd)
+}
+
+func (p *Process) footer(err error) string {
+	elapsed := time.Since(p.Started).Round(time.Millisecond)
+	var exitErr *exec.ExitError
+	switch {
+	case p.stopped != "":
+		return fmt.Sprintf("%s after %s\n", p.stopped, elapsed)
+	case err == nil:
+		return fmt.Sprintf("Finished in %s\n", elapsed)
+	case errors.As(err, &exitErr):
+		return fmt.Sprintf("Exited with code %d in %s\n", exitErr.ExitCode(), elapsed)
+	default:
+		return fmt.Sprintf("Error: %s after %s\n", err, elapsed)
+	}
+}
+
+// RunProcess starts cmd and appends its output to the buffer line by line as it comes, followed by a footer
+// with exit status. A process that is still running in this view is killed first. onExit, if not nil, is
+// called on main loop when the process exits by itself.
+func (e *BufferView) RunProcess(cmd *exec.Cmd, onExit func(err error)) error {
+	p := e.startProcess(&Process{Cmd: cmd})
+	setProcessGroup(cmd)
+	r, w, err := os.Pipe()
+	if err != nil {
+		p.Finished = true
+		return err
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		p.Finished = true
+		return err
+	}
+
+	e.streamOutput(p, r, cmd.Wait, onExit)
+	return nil
+}
+
+// RunFunc is like RunProcess but runs f in a goroutine, interrupting or killing it cancels ctx.
+func (e *BufferView) RunFunc(f func(ctx context.Context, w io.Writer) error, onExit func(err error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := e.startProcess(&Process{cancel: cancel})
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := f(ctx, w)
+		w.Close()
+		cancel()
+		done <- err
+	}()
+	e.streamOutput(p, r, func() error { return <-done }, onExit)
+}
+
+func (e *BufferView) startProcess(p *Process) *Process {
+	if e.process != nil {
+		_ = e.process.Kill()
+	}
+	e.process = p
+	p.Started = time.Now()
+	return p
+}
+
+// streamOutput appends lines read from r to the buffer until EOF, then waits for the process.
+func (e *BufferView) streamOutput(p *Process, r io.ReadCloser, wait func() error, onExit func(err error)) {
+	go func() {
+		reader := bufio.NewReader(r)
+		ansi := NewANSIParser()
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				text, spans := ansi.Parse(bytes.ReplaceAll(line, []byte("\r"), nil))
+				e.parent.Post(func(c *Context) {
+					if e.process == p {
+						e.appendStyled(text, spans)
+					}
+				})
+			}
+			if err != nil {
+				break
+			}
+		}
+		r.Close()
+		err := wait()
+		e.parent.Post(func(c *Context) {
+			p.Finished = true
+			if e.process != p {
+				return
+			}
+			e.Buffer.Append([]byte(p.footer(err)))
+			if onExit != nil && p.stopped == "" {
+				onExit(err)
+			}
+		})
+	}()
+}
+
+// appendStyled appends text to buffer, spans are relative to text.
+func (e *BufferView) appendStyled(text []byte, spans []ANSISpan) {
+	offset := e.Buffer.Content.Len()
+	e.Buffer.Append(text)
+	for _, span := range spans {
+		span.Start += offset
+		span.End += offset
+		e.Buffer.ANSISpans = append(e.Buffer.ANSISpans, span)
+	}
+}
+
+// RerunProcess runs command of a compilation or grep buffer again.
+func RerunProcess(e *BufferView) {
+	if e.rerun != nil {
+		e.rerun()
+	}
+}
+
+func InterruptProcess(e *BufferView) {
+	if e.process != nil {
+		_ = e.process.Interrupt()
+	}
+}
+
+func KillProcess(e *BufferView) {
+	if e.process != nil {
+		_ = e.process.Kill()
+	}
+}
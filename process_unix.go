@@ -0,0 +1,36 @@
+//go:build !windows
+
+package preditor
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command with shell -c, shell defaults to $SHELL and then /bin/sh.
+func shellCommand(shell string, command string) *exec.Cmd {
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return exec.Command(shell, "-c", command)
+}
+
+// child processes get their own process group so we can signal everything they started.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,38 @@
+package preditor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shellCommand runs command with shell /C, shell defaults to %COMSPEC% and then cmd.exe. Command line is
+// passed as is since cmd does its own parsing.
+func shellCommand(shell string, command string) *exec.Cmd {
+	if shell == "" {
+		shell = os.Getenv("COMSPEC")
+	}
+	if shell == "" {
+		shell = "cmd.exe"
+	}
+	cmd := exec.Command(shell)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: fmt.Sprintf("%s /C %s", syscall.EscapeArg(shell), command)}
+	return cmd
+}
+
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
+
+// console programs can't be sent ctrl-c from outside their console, so interrupting kills the tree as well.
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", fmt.Sprint(cmd.Process.Pid)).Run()
+}
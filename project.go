@@ -0,0 +1,208 @@
+package preditor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how long saves and file changes are collected before an automatic build starts.
+const buildDebounce = 300 * time.Millisecond
+
+// how often projects in watch mode are checked for changes.
+const projectWatchInterval = time.Second
+
+// Project keeps automatic build settings of a project root.
+type Project struct {
+	Root          string
+	Command       string
+	CompileOnSave bool
+	Watch         bool
+
+	buildAt      time.Time // when scheduled build starts, zero if none is scheduled
+	view         *BufferView
+	polling      bool
+	lastPoll     time.Time
+	fingerprint  [sha256.Size]byte
+	needBaseline bool
+	baseline     *Process // last build whose output is part of fingerprint
+}
+
+func (p *Project) process() *Process {
+	if p.view == nil {
+		return nil
+	}
+	return p.view.process
+}
+
+func (p *Project) building() bool {
+	proc := p.process()
+	return proc != nil && !proc.Finished
+}
+
+// FindProjectRoot returns closest parent directory of path that has a .git or go.mod, or directory of path
+// if there is none.
+func FindProjectRoot(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	start := filepath.Dir(path)
+	for dir := start; ; dir = filepath.Dir(dir) {
+		for _, marker := range []string{".git", "go.mod"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return dir
+			}
+		}
+		if filepath.Dir(dir) == dir {
+			return start
+		}
+	}
+}
+
+func (e *BufferView) ProjectRoot() string {
+	if e.Buffer.fileType.FindRootOfProject != nil {
+		if root, err := e.Buffer.fileType.FindRootOfProject(e.Buffer.File); err == nil {
+			return root
+		}
+	}
+	return FindProjectRoot(e.Buffer.File)
+}
+
+// ProjectOf returns project of file in e, its command is updated to LastCompileCommand of e.
+func (c *Context) ProjectOf(e *BufferView) *Project {
+	if c.Projects == nil {
+		c.Projects = map[string]*Project{}
+	}
+	root := e.ProjectRoot()
+	p := c.Projects[root]
+	if p == nil {
+		p = &Project{Root: root, CompileOnSave: c.Cfg.CompileOnSave}
+		c.Projects[root] = p
+	}
+	if e.LastCompileCommand != "" {
+		p.Command = e.LastCompileCommand
+	}
+	return p
+}
+
+// ScheduleBuild builds project after buildDebounce, scheduling it again before that postpones the build.
+func (c *Context) ScheduleBuild(p *Project) {
+	p.buildAt = time.Now().Add(buildDebounce)
+}
+
+// BuildProject runs project command in its root and shows output in build window, a build of the project
+// that is still running is cancelled.
+func (c *Context) BuildProject(p *Project) error {
+	if p.Command == "" {
+		return fmt.Errorf("no compile command for %s", p.Root)
+	}
+	view, err := NewCompilationBufferInDir(c, c.Cfg, p.Root, p.Command)
+	if err != nil {
+		return err
+	}
+	if c.GetDrawable(view.ID) != Drawable(view) {
+		c.AddDrawable(view)
+	}
+	c.BuildWindow.DrawableID = view.ID
+	p.view = view
+	return nil
+}
+
+// RunScheduledBuilds starts builds whose debounce time passed and checks projects in watch mode, it's
+// called every frame.
+func (c *Context) RunScheduledBuilds() {
+	for _, p := range c.Projects {
+		if !p.buildAt.IsZero() && time.Now().After(p.buildAt) {
+			p.buildAt = time.Time{}
+			if err := c.BuildProject(p); err != nil {
+				c.ShowMessage(err.Error())
+			}
+		}
+		if p.Watch {
+			c.pollProject(p)
+		}
+	}
+}
+
+// projectFingerprint hashes path, size and mtime of every file under root, hidden directories and
+// node_modules are skipped.
+func projectFingerprint(root string) [sha256.Size]byte {
+	h := sha256.New()
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// pollProject fingerprints project in background and schedules a build if it changed. Files written by a
+// build are not changes: first fingerprint after every build only becomes the new baseline.
+func (c *Context) pollProject(p *Project) {
+	if p.polling || p.building() || time.Since(p.lastPoll) < projectWatchInterval {
+		return
+	}
+	p.polling = true
+	p.lastPoll = time.Now()
+	root := p.Root
+	proc := p.process()
+	go func() {
+		fingerprint := projectFingerprint(root)
+		c.Post(func(c *Context) {
+			p.polling = false
+			if p.process() != proc || p.building() {
+				// a build started meanwhile, fingerprint may be from before it.
+				return
+			}
+			if p.needBaseline || p.baseline != p.process() {
+				p.fingerprint = fingerprint
+				p.baseline = p.process()
+				p.needBaseline = false
+				return
+			}
+			if fingerprint != p.fingerprint {
+				p.fingerprint = fingerprint
+				c.ScheduleBuild(p)
+			}
+		})
+	}()
+}
+
+// CompileOnSave is a save hook that builds project of saved file if it has compile on save enabled.
+func CompileOnSave(e *BufferView) {
+	if p := e.parent.ProjectOf(e); p.CompileOnSave {
+		e.parent.ScheduleBuild(p)
+	}
+}
+
+func ToggleCompileOnSave(e *BufferView) {
+	p := e.parent.ProjectOf(e)
+	p.CompileOnSave = !p.CompileOnSave
+	e.parent.ShowMessage(fmt.Sprintf("Compile on save for %s: %v", p.Root, p.CompileOnSave))
+}
+
+// ToggleBuildWatch toggles building project whenever a file in it changes on disk.
+func ToggleBuildWatch(e *BufferView) {
+	p := e.parent.ProjectOf(e)
+	p.Watch = !p.Watch
+	p.needBaseline = true
+	e.parent.ShowMessage(fmt.Sprintf("Build on file changes for %s: %v", p.Root, p.Watch))
+}
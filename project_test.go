@@ -0,0 +1,104 @@
+package preditor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProject(t *testing.T, command string) (*Context, *BufferView, string) {
+	root := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, ".git"), 0755))
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "pkg"), 0755))
+	path := filepath.Join(root, "pkg", "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("a\n"), 0644))
+
+	c := newTestContext()
+	c.SaveHooks = append(c.SaveHooks, CompileOnSave)
+	view := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(path))
+	c.AddDrawable(view)
+	view.LastCompileCommand = command
+	return c, view, root
+}
+
+// runBuildsUntil runs main loop work, including polling watched projects without waiting for the interval.
+func runBuildsUntil(t *testing.T, c *Context, cond func() bool) {
+	runUntil(t, c, func() bool {
+		for _, p := range c.Projects {
+			p.lastPoll = time.Time{}
+		}
+		c.RunScheduledBuilds()
+		return cond()
+	})
+}
+
+func TestFindProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), nil, 0644))
+	assert.Equal(t, root, FindProjectRoot(filepath.Join(root, "a", "b", "c.go")))
+}
+
+func TestCompileOnSave(t *testing.T) {
+	c, view, root := newTestProject(t, "echo built")
+	Write(view)
+	assert.True(t, c.ProjectOf(view).buildAt.IsZero(), "compile on save is off by default")
+
+	ToggleCompileOnSave(view)
+	p := c.ProjectOf(view)
+	assert.Equal(t, root, p.Root)
+	view.Buffer.Insert(0, []byte("x"))
+	Write(view)
+	first := p.buildAt
+	assert.False(t, first.IsZero())
+	view.Buffer.Insert(0, []byte("y"))
+	Write(view)
+	assert.True(t, p.buildAt.After(first) || p.buildAt.Equal(first))
+
+	runBuildsUntil(t, c, func() bool {
+		return p.view != nil && strings.Contains(string(p.view.Buffer.Content.Bytes()), "Finished in")
+	})
+	assert.Contains(t, string(p.view.Buffer.Content.Bytes()), "Dir: "+root+"\n")
+	assert.Equal(t, 1, strings.Count(string(p.view.Buffer.Content.Bytes()), "\nbuilt\n"))
+	assert.Equal(t, p.view.ID, c.BuildWindow.DrawableID)
+}
+
+func TestBuildCancelsRunningBuild(t *testing.T) {
+	c, view, _ := newTestProject(t, "sleep 30")
+	p := c.ProjectOf(view)
+	assert.NoError(t, c.BuildProject(p))
+	first := p.process()
+	p.Command = "echo second"
+	assert.NoError(t, c.BuildProject(p))
+	runUntil(t, c, func() bool {
+		return first.Finished && strings.Contains(string(p.view.Buffer.Content.Bytes()), "Finished in")
+	})
+	assert.Contains(t, string(p.view.Buffer.Content.Bytes()), "second\n")
+}
+
+func TestBuildWatch(t *testing.T) {
+	// build writes into the project, that must not trigger another build.
+	c, view, root := newTestProject(t, "echo built; date +%N > out.txt")
+	ToggleBuildWatch(view)
+	p := c.ProjectOf(view)
+	runBuildsUntil(t, c, func() bool { return !p.needBaseline })
+	assert.Nil(t, p.view)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "pkg", "new.txt"), []byte("new"), 0644))
+	runBuildsUntil(t, c, func() bool { return p.view != nil && p.process().Finished })
+	build := p.process()
+
+	// polls after the build only take a new baseline.
+	runBuildsUntil(t, c, func() bool { return p.baseline == build })
+	deadline := time.Now().Add(2 * buildDebounce)
+	runBuildsUntil(t, c, func() bool { return time.Now().After(deadline) })
+	assert.True(t, p.buildAt.IsZero())
+	assert.Same(t, build, p.process())
+
+	ToggleBuildWatch(view)
+	assert.False(t, p.Watch)
+}
@@ -0,0 +1,128 @@
+package preditor
+
+import (
+	"fmt"
+	"sort"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+// selectionRegion returns [start, end) of selected text, nil when nothing is selected.
+func (e *BufferView) selectionRegion() []int {
+	if e.Cursor.Start() == e.Cursor.End() {
+		return nil
+	}
+	return []int{e.Cursor.Start(), min(e.nextRune(e.Cursor.End()), e.Buffer.Content.Len())}
+}
+
+// regionContent returns part of content inside region and its offset, whole content when region is nil.
+func regionContent(content []byte, region []int) ([]byte, int) {
+	if region == nil {
+		return content, 0
+	}
+	start, end := min(region[0], len(content)), min(region[1], len(content))
+	return content[start:end], start
+}
+
+func regionLabel(region []int) string {
+	if region == nil {
+		return ""
+	}
+	return " in selection"
+}
+
+func shiftMatches(matches [][]int, offset int) [][]int {
+	for _, m := range matches {
+		m[0] += offset
+		m[1] += offset
+	}
+	return matches
+}
+
+func matchCount(current int, matches [][]int) string {
+	if len(matches) == 0 {
+		return "no matches"
+	}
+	return fmt.Sprintf("match %d/%d", min(current+1, len(matches)), len(matches))
+}
+
+func (s *Search) MatchCount() string {
+	if s.pattern != nil && !s.streamDone {
+		if len(s.SearchMatches) == 0 {
+			return "searching"
+		}
+		return fmt.Sprintf("match %d/%d+", min(s.CurrentMatch+1, len(s.SearchMatches)), len(s.SearchMatches))
+	}
+	return matchCount(s.CurrentMatch, s.SearchMatches)
+}
+
+// searchingView returns another view of the same buffer that is searching or query replacing, its matches
+// are highlighted in this view too.
+func (e *BufferView) searchingView() *BufferView {
+	for _, d := range e.parent.Drawables {
+		if view, ok := d.(*BufferView); ok && view != e && view.Buffer == e.Buffer && (view.Search.IsSearching || view.QueryReplace.IsQueryReplace) {
+			return view
+		}
+	}
+	return nil
+}
+
+// highlightMatches highlights matches that are visible, matches are [start, end] sorted by start.
+func (e *BufferView) highlightMatches(zeroLocation rl.Vector2, matches [][]int, maxH float64, maxW float64) {
+	if len(e.visibleLines) == 0 {
+		return
+	}
+	start := e.visibleLines[0].startIndex
+	end := e.visibleLines[len(e.visibleLines)-1].endIndex
+	bg := e.cfg.CurrentThemeColors().SelectionBackground.ToColorRGBA()
+	fg := e.cfg.CurrentThemeColors().SelectionForeground.ToColorRGBA()
+	for i := sort.Search(len(matches), func(i int) bool { return matches[i][1] >= start }); i < len(matches) && matches[i][0] <= end; i++ {
+		e.highlightBetweenTwoIndexes(zeroLocation, matches[i][0], matches[i][1], maxH, maxW, bg, fg)
+	}
+}
+
+// renderMatchMarkers draws a mark for every line with a match on the right edge of the window, current match
+// is drawn in cursor color.
+func (e *BufferView) renderMatchMarkers(zeroLocation rl.Vector2, maxH float64, maxW float64, matches [][]int, current int) {
+	lines := e.Buffer.Lines()
+	if len(matches) == 0 || lines.LineCount() == 0 {
+		return
+	}
+	charSize := measureTextSize(e.parent.Font, ' ', e.parent.FontSize, 0)
+	height := float64(e.maxLine) * float64(charSize.Y)
+	if height <= 0 || height > maxH {
+		height = maxH
+	}
+	width := max(int32(charSize.X)/2, 2)
+	x := int32(zeroLocation.X) + int32(maxW) - width
+	markerHeight := max(int32(height/float64(lines.LineCount())), 2)
+	lineY := func(offset int) int32 {
+		return int32(zeroLocation.Y) + int32(float64(lines.LineForOffset(offset))/float64(lines.LineCount())*height)
+	}
+	matchColor := rl.Fade(e.cfg.CurrentThemeColors().SelectionBackground.ToColorRGBA(), 0.8)
+	lastY := int32(-1)
+	for _, m := range matches {
+		// many matches end up on the same pixel row in big buffers.
+		if y := lineY(m[0]); y != lastY {
+			rl.DrawRectangle(x, y, width, markerHeight, matchColor)
+			lastY = y
+		}
+	}
+	if current >= 0 && current < len(matches) {
+		rl.DrawRectangle(x, lineY(matches[current][0]), width, markerHeight, e.cfg.CurrentThemeColors().Cursor.ToColorRGBA())
+	}
+}
+
+// renderSearchOfOtherView highlights matches of another view of the same buffer that is searching.
+func (e *BufferView) renderSearchOfOtherView(zeroLocation rl.Vector2, maxH float64, maxW float64) {
+	view := e.searchingView()
+	if view == nil {
+		return
+	}
+	matches, current := view.Search.SearchMatches, view.Search.CurrentMatch
+	if view.QueryReplace.IsQueryReplace {
+		matches, current = view.QueryReplace.SearchMatches[min(view.QueryReplace.CurrentMatch, len(view.QueryReplace.SearchMatches)):], 0
+	}
+	e.highlightMatches(zeroLocation, matches, maxH, maxW)
+	e.renderMatchMarkers(zeroLocation, maxH, maxW, matches, current)
+}
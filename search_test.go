@@ -0,0 +1,101 @@
+package preditor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchInSelection(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, &Buffer{File: "*scratch*", Content: NewPieceTable([]byte("foo bar foo baz foo"))})
+	c.AddDrawable(view)
+	other := NewBufferView(c, c.Cfg, view.Buffer)
+	c.AddDrawable(other)
+
+	view.Cursor = Cursor{Mark: 4, Point: 14}
+	SearchActivate(view)
+	assert.Equal(t, []int{4, 15}, view.Search.Region)
+	c.Prompt.ChangeHook("foo", c)
+	runUntil(t, c, func() bool { return len(view.Search.SearchMatches) > 0 })
+	assert.Equal(t, [][]int{{8, 10}}, view.Search.SearchMatches)
+	assert.Equal(t, "match 1/1", view.Search.MatchCount())
+	assert.Same(t, view, other.searchingView())
+	assert.Nil(t, view.searchingView())
+
+	SearchExit(view)
+	assert.Nil(t, view.Search.Region)
+	assert.Nil(t, other.searchingView())
+
+	view.Cursor = Cursor{}
+	SearchActivate(view)
+	c.Prompt.ChangeHook("fo+", c)
+	runUntil(t, c, func() bool { return len(view.Search.SearchMatches) == 3 })
+	SearchPreviousMatch(view)
+	assert.Equal(t, "match 3/3", view.Search.MatchCount())
+	SearchExit(view)
+}
+
+func TestQueryReplaceInSelection(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, &Buffer{File: "*scratch*", Content: NewPieceTable([]byte("foo bar foo baz foo"))})
+	c.AddDrawable(view)
+	view.Cursor = Cursor{Mark: 14, Point: 4}
+	QueryReplaceActivate(view)
+	assert.Equal(t, "Query in selection [regex]", c.Prompt.Text)
+	c.Prompt.DoneHook("(f)oo", c)
+	c.Prompt.DoneHook("${1}x", c)
+	assert.Equal(t, [][]int{{8, 10}}, view.QueryReplace.SearchMatches)
+	QueryReplaceReplaceAll(view)
+	assert.Equal(t, "foo bar fx baz foo", string(view.Buffer.Content.Bytes()))
+	assert.Nil(t, view.QueryReplace.Region)
+	assert.Equal(t, "no matches", matchCount(0, nil))
+}
+
+func TestSearchAnchors(t *testing.T) {
+	re, err := compileSearchPattern("^bar", SearchOptions{CaseSensitive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{{4, 6}, {8, 10}}, matchPattern([]byte("foo\nbar\nbar\n"), re))
+	re, _ = compileSearchPattern("o$", SearchOptions{})
+	assert.Equal(t, [][]int{{2, 2}}, matchPattern([]byte("foo\nbar\nbar\n"), re))
+}
+
+func TestQueryReplaceTypedKeys(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, &Buffer{File: "*scratch*", Content: NewPieceTable([]byte("foo foo foo"))})
+	c.AddDrawable(view)
+	win := &Window{DrawableID: view.ID}
+	c.AddWindowInANewColumn(win)
+	c.ActiveWindowIndex = win.ID
+	re, _ := compileSearchPattern("foo", SearchOptions{})
+	view.startQueryReplace("foo", "x", re)
+	QueryReplaceReplaceThisMatch(view)
+	c.HandleTextInput('a')
+	assert.Equal(t, "x foo foo", string(view.Buffer.Content.Bytes()))
+	c.HandleTextInput('^')
+	assert.Equal(t, "foo foo foo", string(view.Buffer.Content.Bytes()))
+	c.HandleTextInput('!')
+	assert.Equal(t, "x x x", string(view.Buffer.Content.Bytes()))
+	assert.False(t, view.QueryReplace.IsQueryReplace)
+}
+
+func TestQueryReplaceReadonly(t *testing.T) {
+	setupDefaults()
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, &Buffer{File: "*Grep*", Readonly: true, Content: NewPieceTable([]byte("foo foo"))})
+	c.AddDrawable(view)
+	QueryReplaceActivate(view)
+	assert.False(t, c.Prompt.IsActive)
+	assert.Equal(t, "Query replace: *Grep* is read only", c.StatusMessage)
+
+	re, _ := compileSearchPattern("foo", SearchOptions{})
+	depth := len(view.keymaps.data)
+	view.startQueryReplace("foo", "x", re)
+	assert.False(t, view.QueryReplace.IsQueryReplace)
+	assert.Equal(t, depth, len(view.keymaps.data))
+	assert.Equal(t, "foo foo", string(view.Buffer.Content.Bytes()))
+	assert.Equal(t, State_Clean, view.Buffer.State)
+}
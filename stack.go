@@ -4,11 +4,18 @@ import "errors"
 
 type Stack[T any] struct {
 	data []T
-	size int
+	size int // most elements kept, 0 for no limit
 }
 
+// NewStack returns a history stack keeping last size elements, oldest one is dropped when a push exceeds it.
 func NewStack[T any](size int) *Stack[T] {
-	return &Stack[T]{data: make([]T, size), size: size}
+	return &Stack[T]{data: make([]T, 0, size), size: size}
+}
+
+// NewUnboundedStack returns a stack that grows as needed and never drops elements, for stacks whose bottom
+// must stay like keymaps of a view.
+func NewUnboundedStack[T any](capacity int) *Stack[T] {
+	return &Stack[T]{data: make([]T, 0, capacity)}
 }
 
 var (
@@ -34,7 +41,7 @@ func (s *Stack[T]) Top() (T, error) {
 
 func (s *Stack[T]) Push(e T) {
 	s.data = append(s.data, e)
-	if len(s.data) > s.size {
-		s.data = []T{e}
+	if s.size > 0 && len(s.data) > s.size {
+		s.data = s.data[1:]
 	}
 }
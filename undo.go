@@ -0,0 +1,233 @@
+package preditor
+
+import (
+	"bytes"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	BufferActionType_Insert = iota + 1
+	BufferActionType_Delete
+)
+
+type BufferAction struct {
+	Type int
+	Idx  int
+	Data []byte
+}
+
+// consecutive single character edits closer than this are undone as one step.
+const undoCoalesceTimeout = time.Second
+
+// UndoNode is one undoable step, all of its Actions are undone and redone together.
+type UndoNode struct {
+	Seq          int
+	Time         time.Time
+	Parent       *UndoNode
+	Children     []*UndoNode
+	Actions      []BufferAction
+	CursorBefore Cursor
+	CursorAfter  Cursor
+
+	redoChild int  // index of the child Redo follows
+	typing    bool // node can absorb following single character edits
+}
+
+func (n *UndoNode) Depth() int {
+	var depth int
+	for p := n.Parent; p != nil; p = p.Parent {
+		depth++
+	}
+	return depth
+}
+
+// UndoTree keeps every state the buffer has been in, undoing and then making a new edit starts a new
+// branch instead of throwing away the undone edits.
+type UndoTree struct {
+	Root    *UndoNode
+	Current *UndoNode
+
+	saved       *UndoNode
+	group       *UndoNode
+	groupDepth  int
+	groupCursor Cursor
+	seq         int
+}
+
+func NewUndoTree() *UndoTree {
+	root := &UndoNode{Time: time.Now()}
+	return &UndoTree{Root: root, Current: root, saved: root}
+}
+
+func cursorAfterAction(a BufferAction) Cursor {
+	if a.Type == BufferActionType_Insert {
+		return Cursor{Point: a.Idx + len(a.Data), Mark: a.Idx + len(a.Data)}
+	}
+	return Cursor{Point: a.Idx, Mark: a.Idx}
+}
+
+func (t *UndoTree) newNode(cursor Cursor) *UndoNode {
+	t.seq++
+	n := &UndoNode{Seq: t.seq, Time: time.Now(), Parent: t.Current, CursorBefore: cursor}
+	t.Current.Children = append(t.Current.Children, n)
+	t.Current.redoChild = len(t.Current.Children) - 1
+	t.Current = n
+	return n
+}
+
+// BeginGroup starts a transaction, everything recorded until matching EndGroup is a single undo step.
+// Groups can be nested, only the outermost one counts.
+func (t *UndoTree) BeginGroup(cursor Cursor) {
+	if t.groupDepth == 0 {
+		t.group = nil
+		t.groupCursor = cursor
+	}
+	t.groupDepth++
+}
+
+func (t *UndoTree) EndGroup(cursor Cursor) {
+	if t.groupDepth == 0 {
+		return
+	}
+	t.groupDepth--
+	if t.groupDepth == 0 {
+		if t.group != nil {
+			t.group.CursorAfter = cursor
+		}
+		t.group = nil
+	}
+}
+
+func (t *UndoTree) coalesce(a BufferAction) bool {
+	n := t.Current
+	if n == t.Root || n == t.saved || !n.typing || len(n.Children) > 0 || !isSingleChar(a.Data) {
+		return false
+	}
+	if time.Since(n.Time) > undoCoalesceTimeout {
+		return false
+	}
+	last := &n.Actions[len(n.Actions)-1]
+	if last.Type != a.Type {
+		return false
+	}
+	switch {
+	case a.Type == BufferActionType_Insert && a.Idx == last.Idx+len(last.Data):
+		last.Data = append(last.Data, a.Data...)
+	case a.Type == BufferActionType_Delete && a.Idx == last.Idx:
+		last.Data = append(last.Data, a.Data...)
+	case a.Type == BufferActionType_Delete && a.Idx+len(a.Data) == last.Idx:
+		last.Data = append(bytes.Clone(a.Data), last.Data...)
+		last.Idx = a.Idx
+	default:
+		return false
+	}
+	n.Time = time.Now()
+	n.CursorAfter = cursorAfterAction(a)
+	return true
+}
+
+// Record adds an edit that has been applied to the buffer, cursor is the cursor before the edit.
+func (t *UndoTree) Record(a BufferAction, cursor Cursor) {
+	a.Data = bytes.Clone(a.Data)
+	if t.groupDepth > 0 {
+		if t.group == nil {
+			t.group = t.newNode(t.groupCursor)
+		}
+		t.group.Actions = append(t.group.Actions, a)
+		t.group.CursorAfter = cursorAfterAction(a)
+		return
+	}
+	if t.coalesce(a) {
+		return
+	}
+	n := t.newNode(cursor)
+	n.Actions = []BufferAction{a}
+	n.CursorAfter = cursorAfterAction(a)
+	n.typing = isSingleChar(a.Data)
+}
+
+func isSingleChar(data []byte) bool {
+	r, size := utf8.DecodeRune(data)
+	return size == len(data) && size > 0 && r != '\n' && r != utf8.RuneError
+}
+
+func (t *UndoTree) closeGroup() {
+	t.groupDepth = 0
+	t.group = nil
+}
+
+// Undo moves to parent of current node and returns the node that should be reverted.
+func (t *UndoTree) Undo() *UndoNode {
+	t.closeGroup()
+	if t.Current == t.Root {
+		return nil
+	}
+	n := t.Current
+	t.Current = n.Parent
+	t.SelectRedo(n)
+	return n
+}
+
+// Redo moves to the most recently visited child of current node and returns the node that should be reapplied.
+func (t *UndoTree) Redo() *UndoNode {
+	t.closeGroup()
+	if len(t.Current.Children) == 0 {
+		return nil
+	}
+	t.Current = t.Current.Children[t.Current.redoChild]
+	return t.Current
+}
+
+// SelectRedo makes next Redo follow n, which should be a child of current node.
+func (t *UndoTree) SelectRedo(n *UndoNode) {
+	for i, child := range t.Current.Children {
+		if child == n {
+			t.Current.redoChild = i
+		}
+	}
+}
+
+// PathTo returns nodes that should be undone and then redone, in order, to get from current state to target.
+func (t *UndoTree) PathTo(target *UndoNode) ([]*UndoNode, []*UndoNode) {
+	ancestors := map[*UndoNode]bool{}
+	for n := target; n != nil; n = n.Parent {
+		ancestors[n] = true
+	}
+	var undos []*UndoNode
+	common := t.Current
+	for ; !ancestors[common]; common = common.Parent {
+		undos = append(undos, common)
+	}
+	var redos []*UndoNode
+	for n := target; n != common; n = n.Parent {
+		redos = append([]*UndoNode{n}, redos...)
+	}
+	return undos, redos
+}
+
+// Nodes returns every node except root ordered by creation.
+func (t *UndoTree) Nodes() []*UndoNode {
+	var nodes []*UndoNode
+	var walk func(n *UndoNode)
+	walk = func(n *UndoNode) {
+		for _, child := range n.Children {
+			nodes = append(nodes, child)
+			walk(child)
+		}
+	}
+	walk(t.Root)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Seq < nodes[j].Seq
+	})
+	return nodes
+}
+
+func (t *UndoTree) MarkSaved() {
+	t.saved = t.Current
+}
+
+func (t *UndoTree) IsSaved() bool {
+	return t.saved == t.Current
+}
@@ -0,0 +1,109 @@
+package preditor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBufferView(content string) *BufferView {
+	return &BufferView{
+		Buffer: &Buffer{
+			File:    "",
+			Content: NewPieceTable([]byte(content)),
+		},
+	}
+}
+
+func TestUndoCoalescesTyping(t *testing.T) {
+	bufferView := newTestBufferView("")
+	for _, c := range "hello" {
+		BufferInsertChar(bufferView, c)
+	}
+	BufferInsertChar(bufferView, '\n')
+	BufferInsertChar(bufferView, 'x')
+	assert.Equal(t, "hello\nx", string(bufferView.Buffer.Content.Bytes()))
+
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, "hello\n", string(bufferView.Buffer.Content.Bytes()))
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, "hello", string(bufferView.Buffer.Content.Bytes()))
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, "", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, 0, bufferView.Cursor.Point)
+
+	RedoLastBufferAction(bufferView)
+	assert.Equal(t, "hello", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, 5, bufferView.Cursor.Point)
+}
+
+func TestUndoGroupRestoresSelection(t *testing.T) {
+	bufferView := newTestBufferView("0123456789")
+	bufferView.Cursor = Cursor{Point: 2, Mark: 5}
+	bufferView.BeginUndoGroup()
+	bufferView.deleteSelectionsIfAnySelection()
+	bufferView.AddBytesAtIndex([]byte("abc"), bufferView.Cursor.Point, true)
+	bufferView.EndUndoGroup()
+	assert.Equal(t, "01abc6789", string(bufferView.Buffer.Content.Bytes()))
+
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, "0123456789", string(bufferView.Buffer.Content.Bytes()))
+	assert.Equal(t, Cursor{Point: 2, Mark: 5}, bufferView.Cursor)
+	assert.Nil(t, bufferView.Buffer.History().Undo())
+}
+
+func TestUndoTreeBranches(t *testing.T) {
+	bufferView := newTestBufferView("")
+	bufferView.AddBytesAtIndex([]byte("a"), 0, true)
+	bufferView.AddBytesAtIndex([]byte("b"), 0, true)
+	lost := bufferView.Buffer.History().Current
+	RevertLastBufferAction(bufferView)
+	bufferView.AddBytesAtIndex([]byte("c"), 1, true)
+	assert.Equal(t, "ac", string(bufferView.Buffer.Content.Bytes()))
+
+	bufferView.UndoTo(lost)
+	assert.Equal(t, "ba", string(bufferView.Buffer.Content.Bytes()))
+	assert.Len(t, bufferView.Buffer.History().Nodes(), 3)
+
+	RevertLastBufferAction(bufferView)
+	RedoLastBufferAction(bufferView)
+	assert.Equal(t, "ba", string(bufferView.Buffer.Content.Bytes()))
+}
+
+func TestUndoCleanState(t *testing.T) {
+	bufferView := newTestBufferView("")
+	bufferView.SetStateClean()
+	BufferInsertChar(bufferView, 'a')
+	assert.Equal(t, State_Dirty, bufferView.Buffer.State)
+	RevertLastBufferAction(bufferView)
+	assert.Equal(t, State_Clean, bufferView.Buffer.State)
+}
+
+func TestDescribeUndoNode(t *testing.T) {
+	n := &UndoNode{Actions: []BufferAction{{Type: BufferActionType_Insert, Data: []byte(strings.Repeat("a", 29) + "世界")}}}
+	assert.Equal(t, `insert "`+strings.Repeat("a", 29)+`..."`, describeUndoNode(n))
+}
+
+func TestStackDropsOldest(t *testing.T) {
+	s := NewStack[int](2)
+	s.Push(1)
+	s.Push(2)
+	s.Push(3)
+	top, _ := s.Pop()
+	assert.Equal(t, 3, top)
+	top, _ = s.Pop()
+	assert.Equal(t, 2, top)
+	_, err := s.Pop()
+	assert.ErrorIs(t, err, EmptyStack)
+}
+
+func TestKeymapStackKeepsBottom(t *testing.T) {
+	view := NewBufferView(&Context{}, &defaultConfig, &Buffer{Content: NewPieceTable(nil)})
+	for i := 0; i < 20; i++ {
+		view.keymaps.Push(PromptKeymap)
+	}
+	assert.Len(t, view.keymaps.data, 21)
+	assert.Equal(t, reflect.ValueOf(BufferKeymap).Pointer(), reflect.ValueOf(view.keymaps.data[0]).Pointer())
+}
@@ -0,0 +1,177 @@
+package preditor
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// how often open files are checked for changes made by other programs.
+const fileWatchInterval = time.Second
+
+var ErrFileChangedOnDisk = errors.New("file changed on disk since it was loaded")
+
+// FileState is what we know about a file's content on disk, it's recorded whenever a buffer is loaded or saved.
+type FileState struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+func (s FileState) IsZero() bool {
+	return s.ModTime.IsZero() && s.Size == 0
+}
+
+func newFileState(info os.FileInfo, content []byte) FileState {
+	return FileState{ModTime: info.ModTime(), Size: info.Size(), Hash: sha256.Sum256(content)}
+}
+
+// ReadFileState returns state of file at path, if file has the same mtime and size as known, hash is not
+// recalculated.
+func ReadFileState(path string, known FileState) (FileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileState{}, err
+	}
+	if info.ModTime().Equal(known.ModTime) && info.Size() == known.Size {
+		return known, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return FileState{}, err
+	}
+	return newFileState(info, content), nil
+}
+
+type watchedFile struct {
+	Path  string
+	State FileState
+}
+
+// watchFiles checks every batch of files it receives and reports those whose content differs from their
+// recorded state, runs in its own goroutine.
+func watchFiles(batches <-chan []watchedFile, changes chan<- watchedFile) {
+	for batch := range batches {
+		for _, f := range batch {
+			state, err := ReadFileState(f.Path, f.State)
+			if err != nil || state.Hash == f.State.Hash {
+				continue
+			}
+			changes <- watchedFile{Path: f.Path, State: state}
+		}
+	}
+}
+
+func (c *Context) startFileWatcher() {
+	c.fileWatchBatches = make(chan []watchedFile, 1)
+	c.fileChanges = make(chan watchedFile, 64)
+	go watchFiles(c.fileWatchBatches, c.fileChanges)
+}
+
+// CheckExternalChanges sends open files to the watcher and handles changes it found, it's called every frame.
+func (c *Context) CheckExternalChanges() {
+	if c.fileWatchBatches == nil {
+		return
+	}
+	if time.Since(c.lastFileWatch) >= fileWatchInterval {
+		c.lastFileWatch = time.Now()
+		var batch []watchedFile
+		for _, buf := range c.Buffers {
+			if !buf.DiskState.IsZero() {
+				batch = append(batch, watchedFile{Path: buf.File, State: buf.DiskState})
+			}
+		}
+		select {
+		case c.fileWatchBatches <- batch:
+		default:
+		}
+	}
+
+	for {
+		select {
+		case change := <-c.fileChanges:
+			c.handleExternalChange(change)
+		default:
+			return
+		}
+	}
+}
+
+func (c *Context) bufferViewFor(buf *Buffer) *BufferView {
+	if view, ok := c.ActiveDrawable().(*BufferView); ok && view.Buffer == buf {
+		return view
+	}
+	for _, d := range c.Drawables {
+		if view, ok := d.(*BufferView); ok && view.Buffer == buf {
+			return view
+		}
+	}
+	return nil
+}
+
+func (c *Context) handleExternalChange(change watchedFile) {
+	buf := c.GetBufferByFilename(change.Path)
+	if buf == nil || buf.DiskState.Hash == change.State.Hash || buf.conflict.Hash == change.State.Hash {
+		return
+	}
+	view := c.bufferViewFor(buf)
+	if view == nil {
+		return
+	}
+	if buf.State == State_Clean {
+		if err := view.readFileFromDisk(); err != nil {
+			c.ShowMessage(fmt.Sprintf("Error reverting %s: %s", buf.File, err))
+			return
+		}
+		c.ShowMessage(fmt.Sprintf("Reverted %s, it was changed on disk", buf.File))
+		return
+	}
+	buf.conflict = change.State
+	c.FileConflictPrompt(view)
+}
+
+// FileConflictPrompt asks what to do with a modified buffer whose file was changed by another program.
+func (c *Context) FileConflictPrompt(view *BufferView) {
+	keymap := Keymap{
+		Key{K: "m"}: func(c *Context) {
+			c.ResetPrompt()
+			view.KeepBufferVersion()
+		},
+		Key{K: "d"}: func(c *Context) {
+			c.ResetPrompt()
+			if err := view.readFileFromDisk(); err != nil {
+				c.ShowMessage(fmt.Sprintf("Error reverting %s: %s", view.Buffer.File, err))
+			}
+		},
+		Key{K: "v"}: func(c *Context) {
+			c.ResetPrompt()
+			c.ShowFileDiff(view)
+		},
+		Key{K: "<esc>"}: func(c *Context) {
+			c.ResetPrompt()
+		},
+	}
+	c.SetPrompt(fmt.Sprintf("%s changed on disk: keep (m)ine, take (d)isk, (v)iew diff", view.Buffer.File), nil, nil, &keymap, "")
+}
+
+// KeepBufferVersion accepts current disk version as known so next save overwrites it with buffer content.
+func (e *BufferView) KeepBufferVersion() {
+	state, err := ReadFileState(e.Buffer.File, FileState{})
+	if err != nil {
+		return
+	}
+	e.Buffer.DiskState = state
+	e.Buffer.conflict = FileState{}
+}
+
+// ShowFileDiff opens a buffer with differences between file on disk and the buffer.
+func (c *Context) ShowFileDiff(view *BufferView) {
+	disk, err := os.ReadFile(view.Buffer.File)
+	if err != nil {
+		c.ShowMessage(fmt.Sprintf("Error reading %s: %s", view.Buffer.File, err))
+		return
+	}
+	c.OpenDiffBuffer(view.Buffer.File, "disk", "buffer", disk, view.Buffer.Content.Bytes())
+}
@@ -0,0 +1,75 @@
+package preditor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestContext() *Context {
+	c := &Context{Buffers: map[string]*Buffer{}, DrawablesStack: NewStack[int](10), Cfg: &defaultConfig}
+	message := NewBufferView(c, c.Cfg, &Buffer{File: "*Messages*", Content: NewPieceTable(nil)})
+	c.AddDrawable(message)
+	c.MessageDrawableID = message.ID
+	return c
+}
+
+func TestExternalChanges(t *testing.T) {
+	setupDefaults()
+	path := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("one\n"), 0644))
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(path))
+	c.AddDrawable(view)
+
+	changes := make(chan watchedFile, 1)
+	check := func() {
+		batches := make(chan []watchedFile, 1)
+		batches <- []watchedFile{{Path: path, State: view.Buffer.DiskState}}
+		close(batches)
+		watchFiles(batches, changes)
+		select {
+		case change := <-changes:
+			c.handleExternalChange(change)
+		default:
+		}
+	}
+
+	// clean buffers follow the disk.
+	assert.NoError(t, os.WriteFile(path, []byte("second\n"), 0644))
+	check()
+	assert.Equal(t, "second\n", string(view.Buffer.Content.Bytes()))
+	assert.Equal(t, State_Clean, view.Buffer.State)
+
+	// dirty buffers ask and are not saved over the disk version.
+	BufferInsertChar(view, 'x')
+	assert.NoError(t, os.WriteFile(path, []byte("third version\n"), 0644))
+	check()
+	assert.Equal(t, "xsecond\n", string(view.Buffer.Content.Bytes()))
+	assert.True(t, c.Prompt.IsActive)
+	assert.ErrorIs(t, view.Save(), ErrFileChangedOnDisk)
+
+	c.Prompt.Keymap[Key{K: "m"}](c)
+	assert.NoError(t, view.Save())
+	written, _ := os.ReadFile(path)
+	assert.Equal(t, "xsecond\n", string(written))
+}
+
+func TestRevertError(t *testing.T) {
+	setupDefaults()
+	path := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("one\n"), 0644))
+	c := newTestContext()
+	view := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(path))
+	c.AddDrawable(view)
+
+	// file replaced by a directory.
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, os.Mkdir(path, 0755))
+	assert.Error(t, view.readFileFromDisk())
+	RevertBuffer(view)
+	assert.Contains(t, c.StatusMessage, "Error reverting "+path)
+	assert.Equal(t, "one\n", string(view.Buffer.Content.Bytes()))
+}
@@ -0,0 +1,310 @@
+package preditor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GrepEditKeymap replaces CompileKeymap while a grep buffer is being edited.
+var GrepEditKeymap = Keymap{}
+
+var grepLineFormat = mustErrorFormat("grep", `^(?P<file>.+?):(?P<line>\d+):(?P<col>\d+):`)
+
+type grepLineKey struct {
+	File string
+	Line int
+}
+
+// grepEdit is state of a grep buffer in edit mode, original is text of every matched line when editing
+// started.
+type grepEdit struct {
+	content  []byte
+	original map[grepLineKey]string
+}
+
+// GrepChange is a line of File changed by editing grep output, Line is 1-based.
+type GrepChange struct {
+	File string
+	Line int
+	Old  string
+	New  string
+}
+
+func parseGrepLine(line []byte, dir string) (grepLineKey, string, bool) {
+	loc, ok := ParseLocation([]ErrorFormat{grepLineFormat}, line, dir)
+	if !ok {
+		return grepLineKey{}, "", false
+	}
+	return grepLineKey{File: loc.File, Line: loc.Line}, string(line[loc.End+1:]), true
+}
+
+// GrepEditMode makes a finished grep buffer editable, text after `file:line:col:` can be changed and
+// GrepEditCommit writes it back to the files.
+func GrepEditMode(e *BufferView) {
+	if e.grepEdit != nil {
+		return
+	}
+	if !strings.HasPrefix(e.Buffer.File, "*Grep*") {
+		e.parent.ShowMessage("Only grep output can be edited")
+		return
+	}
+	if e.process != nil && !e.process.Finished {
+		e.parent.ShowMessage("Grep is still running")
+		return
+	}
+	edit := &grepEdit{content: bytes.Clone(e.Buffer.Content.Bytes()), original: map[grepLineKey]string{}}
+	for i := 0; i < e.Buffer.Lines().LineCount(); i++ {
+		if key, text, ok := parseGrepLine(e.lineBytes(i), e.locationDir()); ok {
+			edit.original[key] = text
+		}
+	}
+	e.grepEdit = edit
+	e.Buffer.Readonly = false
+	_, _ = e.keymaps.Pop()
+	e.keymaps.Push(GrepEditKeymap)
+	e.parent.ShowMessage("Editing grep output: Ctrl-s previews and applies changes, Ctrl-q aborts")
+}
+
+func (e *BufferView) exitGrepEdit() {
+	e.grepEdit = nil
+	e.Buffer.Readonly = true
+	e.SetStateClean()
+	_, _ = e.keymaps.Pop()
+	e.keymaps.Push(CompileKeymap)
+}
+
+// GrepEditAbort restores grep output as it was before editing and leaves edit mode.
+func GrepEditAbort(e *BufferView) {
+	if e.grepEdit == nil {
+		return
+	}
+	e.ReplaceContent(e.grepEdit.content)
+	e.exitGrepEdit()
+}
+
+// GrepChanges returns lines whose text was edited since edit mode started sorted by file and line, removed
+// lines are not changes. Same line shown more than once must not be edited differently.
+func (e *BufferView) GrepChanges() ([]GrepChange, error) {
+	if e.grepEdit == nil {
+		return nil, errors.New("grep output is not being edited")
+	}
+	edited := map[grepLineKey]string{}
+	for i := 0; i < e.Buffer.Lines().LineCount(); i++ {
+		key, text, ok := parseGrepLine(e.lineBytes(i), e.locationDir())
+		if !ok {
+			continue
+		}
+		old, known := e.grepEdit.original[key]
+		if !known {
+			return nil, fmt.Errorf("line %d: %s:%d is not in grep output", i+1, key.File, key.Line)
+		}
+		if text == old {
+			continue
+		}
+		if prev, ok := edited[key]; ok && prev != text {
+			return nil, fmt.Errorf("%s:%d is edited differently on more than one line", key.File, key.Line)
+		}
+		edited[key] = text
+	}
+
+	changes := make([]GrepChange, 0, len(edited))
+	for key, text := range edited {
+		changes = append(changes, GrepChange{File: key.File, Line: key.Line, Old: e.grepEdit.original[key], New: text})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].File != changes[j].File {
+			return changes[i].File < changes[j].File
+		}
+		return changes[i].Line < changes[j].Line
+	})
+	return changes, nil
+}
+
+// groupGrepChanges splits sorted changes by file.
+func groupGrepChanges(changes []GrepChange) [][]GrepChange {
+	var groups [][]GrepChange
+	for i, ch := range changes {
+		if i == 0 || ch.File != changes[i-1].File {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], ch)
+	}
+	return groups
+}
+
+// replaceLines replaces lines of content that still have their old text keeping line endings, changes that
+// don't match are returned as conflicts.
+func replaceLines(content []byte, changes []GrepChange) ([]byte, []GrepChange) {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	var conflicts []GrepChange
+	for _, ch := range changes {
+		if ch.Line > len(lines) {
+			conflicts = append(conflicts, ch)
+			continue
+		}
+		line := lines[ch.Line-1]
+		text := bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if string(text) != ch.Old {
+			conflicts = append(conflicts, ch)
+			continue
+		}
+		lines[ch.Line-1] = append([]byte(ch.New), line[len(text):]...)
+	}
+	return bytes.Join(lines, nil), conflicts
+}
+
+// replaceLines is like replaceLines on buffer content but edits go through undo history as one step.
+func (e *BufferView) replaceLines(changes []GrepChange) []GrepChange {
+	var conflicts []GrepChange
+	e.BeginUndoGroup()
+	defer e.EndUndoGroup()
+	for _, ch := range changes {
+		line := ch.Line - 1
+		if line >= e.Buffer.Lines().LineCount() || string(e.lineBytes(line)) != ch.Old {
+			conflicts = append(conflicts, ch)
+			continue
+		}
+		start := e.Buffer.Lines().LineStart(line)
+		e.RemoveRange(start, start+len(ch.Old), true)
+		e.AddBytesAtIndex([]byte(ch.New), start, true)
+	}
+	return conflicts
+}
+
+// bufferForFile returns open buffer of file, if there is one. Large file buffers can't be edited so they are
+// ignored and file is changed on disk.
+func (c *Context) bufferForFile(file string) *Buffer {
+	if buf := c.GetBufferByFilename(file); buf != nil && !buf.Large {
+		return buf
+	}
+	for _, buf := range c.Buffers {
+		if buf.File != "" && buf.File[0] != '*' && !buf.Large && sameFile(buf.File, file) {
+			return buf
+		}
+	}
+	return nil
+}
+
+// GrepChangesDiff returns a unified diff of every file changed by changes, open buffers are diffed instead of
+// files on disk.
+func (c *Context) GrepChangesDiff(changes []GrepChange) ([]byte, error) {
+	var out bytes.Buffer
+	for _, group := range groupGrepChanges(changes) {
+		file := group[0].File
+		var content []byte
+		if buf := c.bufferForFile(file); buf != nil {
+			content = buf.Content.Bytes()
+		} else {
+			var err error
+			if content, err = os.ReadFile(file); err != nil {
+				return nil, err
+			}
+			content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+		}
+		updated, _ := replaceLines(content, group)
+		out.Write(UnifiedDiff(file, file, content, updated))
+	}
+	return out.Bytes(), nil
+}
+
+// ApplyGrepChanges writes changes to their files, open buffers are edited instead and saved unless they had
+// unsaved changes. Lines whose text is not what grep found anymore are skipped and returned as conflicts.
+func (c *Context) ApplyGrepChanges(changes []GrepChange) (int, []GrepChange, error) {
+	var conflicts []GrepChange
+	var errs []error
+	applied := 0
+	for _, group := range groupGrepChanges(changes) {
+		file := group[0].File
+		var failed []GrepChange
+		if buf := c.bufferForFile(file); buf != nil {
+			view := c.bufferViewFor(buf)
+			if view == nil {
+				view = NewBufferView(c, c.Cfg, buf)
+			}
+			wasClean := buf.State == State_Clean
+			failed = view.replaceLines(group)
+			if len(failed) < len(group) {
+				view.SetStateDirty()
+				if wasClean {
+					if err := view.Save(); err != nil {
+						errs = append(errs, fmt.Errorf("%s: %w", file, err))
+					} else {
+						c.RemoveJournal(buf)
+					}
+				}
+			}
+		} else {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			var updated []byte
+			updated, failed = replaceLines(content, group)
+			if len(failed) < len(group) {
+				if err := writeFileAtomic(file, updated, c.Cfg.BackupFiles); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+			}
+		}
+		applied += len(group) - len(failed)
+		conflicts = append(conflicts, failed...)
+	}
+	return applied, conflicts, errors.Join(errs...)
+}
+
+// GrepEditCommit shows a diff of every line changed in grep output and asks before applying them.
+func GrepEditCommit(e *BufferView) {
+	c := e.parent
+	changes, err := e.GrepChanges()
+	if err != nil {
+		c.ShowMessage(err.Error())
+		return
+	}
+	if len(changes) == 0 {
+		c.ShowMessage("No changes")
+		return
+	}
+	diff, err := c.GrepChangesDiff(changes)
+	if err != nil {
+		c.ShowMessage(err.Error())
+		return
+	}
+	preview := NewBufferViewFromFilename(c, c.Cfg, "*Diff*@"+e.Buffer.File)
+	preview.Buffer.Readonly = true
+	preview.Buffer.Reset(diff)
+	c.AddDrawable(preview)
+	c.MarkDrawableAsActive(preview.ID)
+
+	back := func(c *Context) {
+		c.ResetPrompt()
+		c.MarkDrawableAsActive(e.ID)
+	}
+	keymap := Keymap{
+		Key{K: "y"}: func(c *Context) {
+			back(c)
+			applied, conflicts, err := c.ApplyGrepChanges(changes)
+			for _, ch := range conflicts {
+				c.WriteMessage(fmt.Sprintf("%s:%d changed since grep, not replaced", ch.File, ch.Line))
+			}
+			e.exitGrepEdit()
+			switch {
+			case err != nil:
+				c.ShowMessage(fmt.Sprintf("Error applying changes: %s", err))
+			case len(conflicts) > 0:
+				c.ShowMessage(fmt.Sprintf("Replaced %d lines, %d lines changed since grep are listed in *Messages*", applied, len(conflicts)))
+			default:
+				c.ShowMessage(fmt.Sprintf("Replaced %d lines", applied))
+			}
+		},
+		Key{K: "n"}:     back,
+		Key{K: "<esc>"}: back,
+	}
+	c.SetPrompt(fmt.Sprintf("Apply %d changed lines in %d files? (y)es, (n)o", len(changes), len(groupGrepChanges(changes))),
+		nil, nil, &keymap, "")
+}
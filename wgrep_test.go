@@ -0,0 +1,114 @@
+package preditor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGrepView(c *Context, root string, output string) *BufferView {
+	view := NewBufferViewFromFilename(c, c.Cfg, "*Grep*@"+root)
+	view.Buffer.Readonly = true
+	view.Buffer.Reset([]byte(output))
+	view.Locations = newLocationList(root)
+	view.keymaps.Push(CompileKeymap)
+	c.AddDrawable(view)
+	return view
+}
+
+func TestGrepChanges(t *testing.T) {
+	setupDefaults()
+	root := t.TempDir()
+	c := newTestContext()
+	view := newTestGrepView(c, root, "Pattern: foo\nDir: "+root+"\na.go:1:1:foo foo\na.go:1:5:foo foo\nb.go:3:2: foo()\n")
+
+	_, err := view.GrepChanges()
+	assert.Error(t, err)
+	GrepEditMode(view)
+	assert.False(t, view.Buffer.Readonly)
+
+	changes, err := view.GrepChanges()
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	view.ReplaceContent([]byte("Pattern: foo\nDir: " + root + "\na.go:1:1:bar bar\na.go:1:5:foo foo\nb.go:3:2: baz()\n"))
+	changes, err = view.GrepChanges()
+	assert.NoError(t, err)
+	assert.Equal(t, []GrepChange{
+		{File: filepath.Join(root, "a.go"), Line: 1, Old: "foo foo", New: "bar bar"},
+		{File: filepath.Join(root, "b.go"), Line: 3, Old: " foo()", New: " baz()"},
+	}, changes)
+
+	view.ReplaceContent([]byte("Pattern: foo\nDir: " + root + "\na.go:1:1:bar foo\na.go:1:5:foo bar\n"))
+	_, err = view.GrepChanges()
+	assert.ErrorContains(t, err, "edited differently")
+
+	view.ReplaceContent([]byte("a.go:2:1:foo\n"))
+	_, err = view.GrepChanges()
+	assert.ErrorContains(t, err, "not in grep output")
+
+	GrepEditAbort(view)
+	assert.True(t, view.Buffer.Readonly)
+	assert.Nil(t, view.grepEdit)
+	assert.Equal(t, "Pattern: foo\nDir: "+root+"\na.go:1:1:foo foo\na.go:1:5:foo foo\nb.go:3:2: foo()\n", string(view.Buffer.Content.Bytes()))
+}
+
+func TestReplaceLines(t *testing.T) {
+	updated, conflicts := replaceLines([]byte("one\r\ntwo\r\nthree"), []GrepChange{
+		{Line: 1, Old: "one", New: "1"},
+		{Line: 2, Old: "TWO", New: "2"},
+		{Line: 3, Old: "three", New: "3"},
+		{Line: 9, Old: "nine", New: "9"},
+	})
+	assert.Equal(t, "1\r\ntwo\r\n3", string(updated))
+	assert.Equal(t, []int{2, 9}, []int{conflicts[0].Line, conflicts[1].Line})
+}
+
+func TestApplyGrepChanges(t *testing.T) {
+	setupDefaults()
+	root := writeTree(t, map[string]string{
+		"closed.txt": "foo\r\nkeep\r\nfoo\r\n",
+		"clean.txt":  "foo\n",
+		"dirty.txt":  "foo\nother\n",
+	})
+	c := newTestContext()
+	clean := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(filepath.Join(root, "clean.txt")))
+	c.AddDrawable(clean)
+	dirty := NewBufferView(c, c.Cfg, c.OpenFileAsBuffer(filepath.Join(root, "dirty.txt")))
+	c.AddDrawable(dirty)
+	dirty.ReplaceContent([]byte("foo\nedited\n"))
+	dirty.SetStateDirty()
+
+	view := newTestGrepView(c, root, "closed.txt:1:1:foo\nclosed.txt:3:1:foo\nclean.txt:1:1:foo\ndirty.txt:1:1:foo\ndirty.txt:2:1:other\n")
+	GrepEditMode(view)
+	view.ReplaceContent([]byte("closed.txt:1:1:bar\nclosed.txt:3:1:baz\nclean.txt:1:1:bar\ndirty.txt:1:1:bar\ndirty.txt:2:1:changed\n"))
+	changes, err := view.GrepChanges()
+	assert.NoError(t, err)
+
+	diff, err := c.GrepChangesDiff(changes)
+	assert.NoError(t, err)
+	assert.Contains(t, string(diff), "-foo\n+baz\n")
+	assert.Contains(t, string(diff), "-foo\n+bar\n edited\n")
+
+	applied, conflicts, err := c.ApplyGrepChanges(changes)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, applied)
+	assert.Equal(t, []GrepChange{{File: filepath.Join(root, "dirty.txt"), Line: 2, Old: "other", New: "changed"}}, conflicts)
+
+	closed, _ := os.ReadFile(filepath.Join(root, "closed.txt"))
+	assert.Equal(t, "bar\r\nkeep\r\nbaz\r\n", string(closed))
+
+	onDisk, _ := os.ReadFile(filepath.Join(root, "clean.txt"))
+	assert.Equal(t, "bar\n", string(onDisk))
+	assert.Equal(t, State_Clean, clean.Buffer.State)
+
+	onDisk, _ = os.ReadFile(filepath.Join(root, "dirty.txt"))
+	assert.Equal(t, "foo\nother\n", string(onDisk))
+	assert.Equal(t, "bar\nedited\n", string(dirty.Buffer.Content.Bytes()))
+	assert.Equal(t, State_Dirty, dirty.Buffer.State)
+
+	RevertLastBufferAction(dirty)
+	assert.Equal(t, "foo\nedited\n", string(dirty.Buffer.Content.Bytes()))
+}